@@ -41,8 +41,16 @@ type Config struct {
 	BaseDir     string       `mapstructure:"base_dir"`
 	DirManagers []DirManager `mapstructure:"dir_managers"`
 
+	// upload input files through ComfyUI API instead of syncing to BaseDir,
+	// for ComfyUI which has no shared disk with driver
+	UploadInput bool `mapstructure:"upload_input"`
+
 	MaxTimeout time.Duration `mapstructure:"max_timeout"`
 
+	// SharedWs receive the messages of all prompts through one WebSocket,
+	// the clientID of each generation is replaced by the shared one
+	SharedWs bool `mapstructure:"shared_ws"`
+
 	RetryTimes int `mapstructure:"retry_times"`
 
 	// RAMFreeThreshold is the threshold of free RAM usage
@@ -68,6 +76,13 @@ func WithLogger(l logger.LoggerExtend) Option {
 	}
 }
 
+// WithClientOptions pass opts to the ComfyUI client, e.g.: a recorder
+func WithClientOptions(opts ...comfyui.Option) Option {
+	return func(d *Driver) {
+		d.clientOpts = append(d.clientOpts, opts...)
+	}
+}
+
 func New(ctx context.Context, c Config, opts ...Option) (*Driver, error) {
 	// some default fix
 	if c.FS.Mode == "" {
@@ -86,11 +101,13 @@ func New(ctx context.Context, c Config, opts ...Option) (*Driver, error) {
 		Config:  c,
 		Handler: fsHandler,
 		Logger:  logger.NewStd(),
+
+		timeHistory: session.NewTimeHistory(),
 	}
 	for _, opt := range opts {
 		opt(d)
 	}
-	cli, err := comfyui.New(c.ComfyUI, comfyui.WithLogger(d.Logger))
+	cli, err := comfyui.New(c.ComfyUI, append([]comfyui.Option{comfyui.WithLogger(d.Logger)}, d.clientOpts...)...)
 	if err != nil {
 		return nil, fmt.Errorf("new comfyui cli: %w", err)
 	}
@@ -144,19 +161,29 @@ type Driver struct {
 	Handler fileop.FileSystemSimpleBucket
 	Config
 	fManagerMap map[string]filemanager.IFileManager
+	clientOpts  []comfyui.Option
+	// timeHistory weights the progress by the node time of previous prompts
+	timeHistory *session.TimeHistory
 
 	Logger logger.LoggerExtend
 }
 
 func (d *Driver) Stop() {
 	d.Logger.Infof("driver shutdown...")
-
+	if err := d.Client.Close(); err != nil {
+		d.Logger.Warnf("close comfyui client: %v", err)
+	}
 	d.Logger.Infof("driver exit")
 }
 
 type NewDataFunc func(clientID string) (data map[string]any, totalNodes int, isTriggerNodeID map[string]string)
 
 func (d *Driver) CommonGenerate(newData NewDataFunc, bucket string, tmplStr, taskID, clientID, newPromptID string, progressChan chan<- iface.ProgressInfo) (*DriverSessionResult, error) {
+	return d.CommonGenerateContext(context.Background(), newData, bucket, tmplStr, taskID, clientID, newPromptID, progressChan)
+}
+
+// CommonGenerateContext is like CommonGenerate, ctx cancels the prompt submit and output downloads
+func (d *Driver) CommonGenerateContext(ctx context.Context, newData NewDataFunc, bucket string, tmplStr, taskID, clientID, newPromptID string, progressChan chan<- iface.ProgressInfo) (*DriverSessionResult, error) {
 	tmpl := defaultFilenameTmpl
 	if tmplStr != "" {
 		var err error
@@ -165,7 +192,7 @@ func (d *Driver) CommonGenerate(newData NewDataFunc, bucket string, tmplStr, tas
 			return nil, fmt.Errorf("filename tmpl parse: %w", err)
 		}
 	}
-	return d.commonGenerate(newData, bucket, tmpl, taskID, clientID, newPromptID, progressChan)
+	return d.commonGenerate(ctx, newData, bucket, tmpl, taskID, clientID, newPromptID, progressChan)
 }
 
 type NodeOutputDetail struct {
@@ -228,6 +255,7 @@ func (d *Driver) NewSession(
 }
 
 func (d *Driver) commonGenerate(
+	ctx context.Context,
 	newData NewDataFunc,
 	bucket string,
 	nameTmpl *template.Template,
@@ -247,7 +275,15 @@ func (d *Driver) commonGenerate(
 	wg := new(sync.WaitGroup)
 	defer wg.Wait()
 
-	if clientID == "" {
+	var mux *comfyui.WsMux
+	if d.SharedWs {
+		var err error
+		if mux, err = d.WsMux(); err != nil {
+			// result is used by the defers above
+			return result, fmt.Errorf("ws mux: %w", err)
+		}
+		clientID = mux.ClientID()
+	} else if clientID == "" {
 		clientID = uuid.New().String()
 	}
 
@@ -289,20 +325,35 @@ func (d *Driver) commonGenerate(
 		totalNodes,
 		progressChan,
 	)
+	sess.SetContext(ctx)
+	sess.NodeClassTypes = session.ClassTypesOf(data["prompt"])
+	sess.NodeLinks = session.LinksOf(data["prompt"])
+	sess.TimeHistory = d.timeHistory
 	defer func() {
-		result.NodesTime = sess.NodesTime
+		result.NodesTime = sess.Snapshot().NodesTime
 	}()
 
-	processWg, err := d.SimpleProcess(clientID, &session.WrapSession{Session: sess})
-	if err != nil {
-		return nil, fmt.Errorf("consume process: %w", err)
+	consumer := &session.WrapSession{Session: sess}
+	var processWg *sync.WaitGroup
+	if mux == nil {
+		var err error
+		processWg, err = d.SimpleProcess(clientID, consumer)
+		if err != nil {
+			return nil, fmt.Errorf("consume process: %w", err)
+		}
 	}
-	defer processWg.Wait()
+	defer func() {
+		if processWg != nil {
+			processWg.Wait()
+		}
+	}()
 
 	var promptID string
 	defer func() {
-		// wait for session complete
-		resMap := sess.Wait(d.MaxTimeout)
+		// wait for session complete, the prompt is interrupted if ctx is done or timeout
+		waitCtx, cancel := context.WithTimeout(ctx, d.MaxTimeout)
+		defer cancel()
+		resMap := sess.WaitContext(waitCtx)
 		if promptID != "" {
 			res := resMap[promptID]
 			if finalErr == nil {
@@ -316,15 +367,18 @@ func (d *Driver) commonGenerate(
 		}
 	}()
 
-	resp, err := d.Prompt(data)
+	resp, err := d.PromptContext(ctx, data)
 	if err != nil {
 		finalErr = fmt.Errorf("send prompt resp: %w", err)
 		return
 	}
 	promptID = resp.PromptID
-	sess.StoreResp(promptID, session.RespResult{
-		QPResp:    *resp,
-		ErrorChan: make(chan error, 1),
-	})
+	sess.Track(*resp)
+	if mux != nil {
+		// registered after Track, the messages arrived before are kept by mux
+		if processWg, err = mux.Process(promptID, consumer); err != nil {
+			consumer.OnGiveUp(fmt.Errorf("consume process: %w", err))
+		}
+	}
 	return
 }
@@ -1,6 +1,7 @@
 package driver
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -115,6 +116,12 @@ type Response struct {
 }
 
 func (d *Driver) HandlePrompt(req Request, taskID, clientID, newPromptID string, progressChan chan<- iface.ProgressInfo) (*Response, error) {
+	return d.HandlePromptContext(context.Background(), req, taskID, clientID, newPromptID, progressChan)
+}
+
+// HandlePromptContext is like HandlePrompt, ctx cancels the input syncing,
+// prompt submit and output downloads
+func (d *Driver) HandlePromptContext(ctx context.Context, req Request, taskID, clientID, newPromptID string, progressChan chan<- iface.ProgressInfo) (*Response, error) {
 	start := time.Now()
 
 	// workflow to obj
@@ -127,7 +134,7 @@ func (d *Driver) HandlePrompt(req Request, taskID, clientID, newPromptID string,
 		return nil, fmt.Errorf("unmarshal workflow: %w", err)
 	}
 
-	syncDuration, err := d.syncInputFiles(req)
+	syncDuration, err := d.syncInputFiles(ctx, req)
 	if err != nil {
 		return nil, fmt.Errorf("sync input files: %w", err)
 	}
@@ -161,7 +168,7 @@ func (d *Driver) HandlePrompt(req Request, taskID, clientID, newPromptID string,
 		}
 		return out, len(workflowObj), isTriggerNodeID
 	}
-	result, err := d.CommonGenerate(newData, req.Bucket, "", taskID, clientID, newPromptID, progressChan)
+	result, err := d.CommonGenerateContext(ctx, newData, req.Bucket, "", taskID, clientID, newPromptID, progressChan)
 	if err != nil {
 		return nil, err
 	}
@@ -184,7 +191,7 @@ func (d *Driver) HandlePrompt(req Request, taskID, clientID, newPromptID string,
 
 // syncInputFiles synchronizes input files based on the request configuration.
 // It calculates and returns the time taken for the synchronization.
-func (d *Driver) syncInputFiles(req Request) (time.Duration, error) {
+func (d *Driver) syncInputFiles(ctx context.Context, req Request) (time.Duration, error) {
 	cost := time.Duration(0)
 	if req.Bucket != "" {
 		for _, input := range req.Inputs {
@@ -198,7 +205,7 @@ func (d *Driver) syncInputFiles(req Request) (time.Duration, error) {
 			}
 			files := input.Files
 			start := time.Now()
-			if err := d.prepareInputFiles(inputBucket, syncTo, files...); err != nil {
+			if err := d.prepareInputFiles(ctx, inputBucket, syncTo, files...); err != nil {
 				return 0, fmt.Errorf("prepare input files for input %+v: %w", input, err)
 			}
 			cost += time.Since(start)
@@ -207,7 +214,11 @@ func (d *Driver) syncInputFiles(req Request) (time.Duration, error) {
 	return cost, nil
 }
 
-func (d *Driver) prepareInputFiles(bucket, syncTo string, files ...string) error {
+func (d *Driver) prepareInputFiles(ctx context.Context, bucket, syncTo string, files ...string) error {
+	if d.UploadInput && syncTo == SubDirInput {
+		return d.uploadInputFiles(ctx, bucket, files...)
+	}
+
 	fm, ok := d.fManagerMap[syncTo]
 	if !ok {
 		return fmt.Errorf("sync_to %q is not support", syncTo)
@@ -218,6 +229,9 @@ func (d *Driver) prepareInputFiles(bucket, syncTo string, files ...string) error
 	}
 
 	for i, name := range files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		d.Logger.Infof("syncing file %d/%d: %s", i+1, len(files), name)
 		if err := fm.SyncFile(name, func() (io.ReadCloser, error) {
 			return d.Handler.Bucket(bucket).Open(name)
@@ -232,3 +246,42 @@ func (d *Driver) prepareInputFiles(bucket, syncTo string, files ...string) error
 
 	return nil
 }
+
+func (d *Driver) uploadInputFiles(ctx context.Context, bucket string, files ...string) error {
+	if len(files) > 0 {
+		d.Logger.Infof("uploading %d input files from bucket %s", len(files), bucket)
+	}
+
+	for i, name := range files {
+		d.Logger.Infof("uploading file %d/%d: %s", i+1, len(files), name)
+		if err := d.uploadInputFile(ctx, bucket, name); err != nil {
+			return fmt.Errorf("upload %s: %w", name, err)
+		}
+	}
+
+	if len(files) > 0 {
+		d.Logger.Infof("completed uploading %d input files", len(files))
+	}
+
+	return nil
+}
+
+func (d *Driver) uploadInputFile(ctx context.Context, bucket, name string) error {
+	rc, err := d.Handler.Bucket(bucket).Open(name)
+	if err != nil {
+		return fmt.Errorf("open: %w", err)
+	}
+	defer rc.Close()
+
+	// keep the same path as syncing to input dir
+	subfolder := filepath.ToSlash(filepath.Dir(filepath.Clean(name)))
+	if subfolder == "." {
+		subfolder = ""
+	}
+	_, err = d.UploadImage(ctx, rc, comfyui.UploadOptions{
+		Filename:  filepath.Base(name),
+		Subfolder: subfolder,
+		Overwrite: true,
+	})
+	return err
+}
@@ -0,0 +1,36 @@
+package driver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	comfyui "github.com/sko00o/comfyui-go"
+	"github.com/sko00o/comfyui-go/logger"
+)
+
+func TestDriver_WsMuxFailed(t *testing.T) {
+	// no ws endpoint, the mux can not connect
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+	cli, err := comfyui.New(comfyui.Config{Endpoint: srv.URL})
+	require.NoError(t, err)
+	d := &Driver{
+		Client: cli,
+		Config: Config{SharedWs: true},
+		Logger: logger.NewStd(),
+	}
+
+	newData := func(string) (map[string]any, int, map[string]string) {
+		t.Fatal("no data is built without ws")
+		return nil, 0, nil
+	}
+	result, err := d.commonGenerate(context.Background(), newData, "", defaultFilenameTmpl, "t1", "", "", nil)
+	assert.ErrorContains(t, err, "ws mux")
+	require.NotNil(t, result)
+	assert.NotNil(t, result.NodeOutput)
+}
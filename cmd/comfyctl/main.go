@@ -9,6 +9,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/marsgopher/mahou"
@@ -20,6 +21,7 @@ import (
 	"github.com/sko00o/comfyui-go/graph"
 	"github.com/sko00o/comfyui-go/iface"
 	"github.com/sko00o/comfyui-go/logger"
+	"github.com/sko00o/comfyui-go/session"
 
 	"github.com/sko00o/comfyui-go/cmd/comfyctl/driver"
 )
@@ -36,6 +38,16 @@ func WrapHandleErr(err error) (errObj map[string]any, isOOM bool) {
 			errObj["comfyui_err"] = nil
 		}
 		errObj["nodes_time"] = cuiErr.NodesTime
+		if nodeErrors := cuiErr.NodeErrors(); len(nodeErrors) > 0 {
+			errObj["node_errors"] = nodeErrors
+			errObj["missing_model"] = cuiErr.MissingModel()
+		}
+	}
+	var cancelErr *session.CanceledError
+	if errors.As(err, &cancelErr) {
+		errObj["canceled"] = true
+		errObj["nodes_time"] = cancelErr.NodesTime
+		errObj["saved_files"] = cancelErr.Files
 	}
 	return
 }
@@ -106,8 +118,8 @@ func processWorkflowFile(ctx context.Context, file string, recordFile string, dr
 	if err != nil {
 		return fmt.Errorf("processing workflow: %w", err)
 	}
-	if res.NodeErrors != nil && string(res.NodeErrors) != "{}" {
-		return fmt.Errorf("workflow node errors: %s", res.NodeErrors)
+	if len(res.NodeErrors) > 0 {
+		return fmt.Errorf("workflow node errors:\n%s", res.NodeErrors)
 	} else {
 		log.Debugf("response: %+v", res)
 
@@ -157,8 +169,8 @@ func run(ctx context.Context, cfg Config) error {
 		return nil
 	}
 
-	// setup comfyui base url for object_info fetch
-	fetcher := graph.NewCachedHTTPObjectInfoFetcher(cfg.SD.ComfyUI.Endpoint)
+	// share the comfyui client for object_info fetch
+	fetcher := graph.NewCachedClientObjectInfoFetcher(dr.Client)
 	converter := graph.NewGraphConverter(fetcher)
 
 	recordDir := cfg.RecordDir
@@ -188,18 +200,23 @@ func run(ctx context.Context, cfg Config) error {
 	return nil
 }
 
-func process(dr *driver.Driver, taskID, clientID string, req driver.Request) (*driver.Response, error) {
+func process(ctx context.Context, dr *driver.Driver, taskID, clientID string, req driver.Request) (*driver.Response, error) {
 	progressChan := make(chan iface.ProgressInfo, 1)
 	defer close(progressChan)
 	go func() {
 		for progress := range progressChan {
+			if progress.Max > 0 {
+				log.Infof("task %s progress is %d%% on node #%s %s, step %d/%d, eta %v", taskID,
+					progress.PercentNum, progress.NodeID, progress.ClassType, progress.Step, progress.Max, progress.ETA.Round(time.Second))
+				continue
+			}
 			log.Infof("task %s progress is %d%% on node #%s", taskID, progress.PercentNum, progress.NodeID)
 		}
 	}()
 	if clientID == "" {
 		clientID = taskID
 	}
-	return dr.HandlePrompt(req, taskID, clientID, "", progressChan)
+	return dr.HandlePromptContext(ctx, req, taskID, clientID, "", progressChan)
 }
 
 func processRetryOnOOM(ctx context.Context, dr *driver.Driver, taskID, clientID string, req driver.Request) (*driver.Response, error) {
@@ -208,7 +225,7 @@ func processRetryOnOOM(ctx context.Context, dr *driver.Driver, taskID, clientID
 	var err error
 
 	for attempt := 0; attempt <= maxRetries; attempt++ {
-		res, err = process(dr, taskID, clientID, req)
+		res, err = process(ctx, dr, taskID, clientID, req)
 		if err != nil {
 			if _, isOOM := WrapHandleErr(err); isOOM && attempt < maxRetries {
 				if attempt == 0 {
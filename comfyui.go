@@ -2,25 +2,47 @@ package comfyui
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
+	"mime/multipart"
 	"net"
 	"net/http"
 	"net/url"
+	"strings"
 	"sync"
 	"time"
 
 	comfyError "github.com/sko00o/comfyui-go/error"
+	"github.com/sko00o/comfyui-go/iface"
 	"github.com/sko00o/comfyui-go/logger"
+	"github.com/sko00o/comfyui-go/ws"
 )
 
 type Config struct {
 	Endpoint string `mapstructure:"endpoint"`
 
+	// Timeout is the default deadline of each request,
+	// it is only applied when the request context has no deadline.
+	// It is applied by the transport instead of http.Client.Timeout,
+	// so the requests sent by the embedded http.Client directly also have it
 	Timeout       time.Duration `mapstructure:"timeout"`
 	DialTimeout   time.Duration `mapstructure:"dial_timeout"`
 	DialKeepAlive time.Duration `mapstructure:"dial_keep_alive"`
+
+	// Headers are set on every HTTP and WebSocket request
+	Headers     map[string]string `mapstructure:"headers"`
+	BearerToken string            `mapstructure:"bearer_token"`
+	BasicAuth   *BasicAuth        `mapstructure:"basic_auth"`
+	TLS         TLSConfig         `mapstructure:"tls"`
+
+	Retry RetryConfig `mapstructure:"retry"`
+	// WsReconnect is the reconnect policy of WebSocket clients
+	WsReconnect ws.ReconnectConfig `mapstructure:"ws_reconnect"`
+	// WsKeepalive is the ping/pong policy of WebSocket clients
+	WsKeepalive ws.KeepaliveConfig `mapstructure:"ws_keepalive"`
 }
 
 type Client struct {
@@ -28,7 +50,23 @@ type Client struct {
 	BaseURL url.URL
 	apiMap  sync.Map
 
-	log logger.LoggerExtend
+	timeout time.Duration
+	retry   RetryConfig
+
+	// editors mutate every request before sending
+	editors   []RequestEditorFn
+	tlsConfig *tls.Config
+	transport http.RoundTripper
+	wsOpts    []ws.Option
+	// wsReconnect and wsKeepalive are applied before wsOpts
+	wsReconnect ws.ReconnectConfig
+	wsKeepalive ws.KeepaliveConfig
+
+	muxMu sync.Mutex
+	mux   *WsMux
+
+	metrics iface.Metrics
+	log     logger.LoggerExtend
 }
 
 type Option func(c *Client)
@@ -39,32 +77,142 @@ func WithLogger(l logger.LoggerExtend) Option {
 	}
 }
 
+// WithRequestEditor append fn to the chain of request editors
+func WithRequestEditor(fn RequestEditorFn) Option {
+	return func(c *Client) {
+		c.editors = append(c.editors, fn)
+	}
+}
+
+func WithHeader(key, value string) Option {
+	return WithRequestEditor(func(req *http.Request) error {
+		req.Header.Set(key, value)
+		return nil
+	})
+}
+
+func WithBearerToken(token string) Option {
+	return WithHeader("Authorization", "Bearer "+token)
+}
+
+func WithBasicAuth(username, password string) Option {
+	return WithRequestEditor(func(req *http.Request) error {
+		req.SetBasicAuth(username, password)
+		return nil
+	})
+}
+
+// WithTLSConfig set TLS config for both HTTP and WebSocket,
+// it is ignored by HTTP if WithTransport is set
+func WithTLSConfig(tlsConfig *tls.Config) Option {
+	return func(c *Client) {
+		c.tlsConfig = tlsConfig
+	}
+}
+
+// WithTransport replace the default HTTP transport
+func WithTransport(rt http.RoundTripper) Option {
+	return func(c *Client) {
+		c.transport = rt
+	}
+}
+
+// WithMetrics report the requests to m, it is shared with ws clients and sessions
+func WithMetrics(m iface.Metrics) Option {
+	return func(c *Client) {
+		c.metrics = m
+	}
+}
+
+// WithWsOptions append opts to the options of WebSocket clients created by c
+func WithWsOptions(opts ...ws.Option) Option {
+	return func(c *Client) {
+		c.wsOpts = append(c.wsOpts, opts...)
+	}
+}
+
 func New(c Config, opts ...Option) (*Client, error) {
 	u, err := url.Parse(c.Endpoint)
 	if err != nil {
 		return nil, fmt.Errorf("parse endpoint: %w", err)
 	}
+	tlsConfig, err := c.TLS.Build()
+	if err != nil {
+		return nil, fmt.Errorf("tls config: %w", err)
+	}
 	cli := &Client{
-		Client: &http.Client{
-			Timeout: c.Timeout,
-			Transport: &http.Transport{
-				DialContext: (&net.Dialer{
-					Timeout:   c.DialTimeout,
-					KeepAlive: c.DialKeepAlive,
-				}).DialContext,
-			},
-		},
 		BaseURL: *u,
+		timeout: c.Timeout,
+		retry:   c.Retry.withDefault(),
+
+		wsReconnect: c.WsReconnect,
+		wsKeepalive: c.WsKeepalive,
+
+		editors:   c.editors(),
+		tlsConfig: tlsConfig,
 
-		log: logger.NewStd(),
+		metrics: iface.NopMetrics{},
+		log:     logger.NewStd(),
 	}
 	for _, opt := range opts {
 		opt(cli)
 	}
 
+	transport := cli.transport
+	if transport == nil {
+		transport = &http.Transport{
+			DialContext: (&net.Dialer{
+				Timeout:   c.DialTimeout,
+				KeepAlive: c.DialKeepAlive,
+			}).DialContext,
+			TLSClientConfig: cli.tlsConfig,
+		}
+	}
+	cli.Client = &http.Client{
+		Transport: &timeoutTransport{next: transport, timeout: c.Timeout},
+	}
+
 	return cli, nil
 }
 
+// timeoutTransport apply the default timeout to the request without deadline,
+// the timeout lasts until the response body is closed
+type timeoutTransport struct {
+	next    http.RoundTripper
+	timeout time.Duration
+}
+
+func (t *timeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if _, ok := req.Context().Deadline(); ok || t.timeout <= 0 {
+		return t.next.RoundTrip(req)
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), t.timeout)
+	resp, err := t.next.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// CloseIdleConnections is called by http.Client.CloseIdleConnections
+func (t *timeoutTransport) CloseIdleConnections() {
+	if ci, ok := t.next.(interface{ CloseIdleConnections() }); ok {
+		ci.CloseIdleConnections()
+	}
+}
+
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	defer b.cancel()
+	return b.ReadCloser.Close()
+}
+
 type api struct {
 	Once sync.Once
 	URL  string
@@ -74,14 +222,13 @@ func (c *Client) reqURL(path ReqPath) string {
 	got, _ := c.apiMap.LoadOrStore(path, &api{})
 	a := got.(*api)
 	a.Once.Do(func() {
-		u := c.BaseURL
-		u.Path = string(path)
-		a.URL = u.String()
+		// keep the path prefix of BaseURL, e.g.: ComfyUI behind a reverse proxy at /comfy/
+		a.URL = c.BaseURL.JoinPath(string(path)).String()
 	})
 	return a.URL
 }
 
-func (c *Client) newJSONReq(method, urlStr string, data any) (req *http.Request, err error) {
+func (c *Client) newJSONReq(ctx context.Context, method, urlStr string, data any) (req *http.Request, err error) {
 	var body io.Reader
 	if data != nil {
 		var buf bytes.Buffer
@@ -91,7 +238,7 @@ func (c *Client) newJSONReq(method, urlStr string, data any) (req *http.Request,
 		body = &buf
 	}
 
-	req, err = http.NewRequest(method, urlStr, body)
+	req, err = http.NewRequestWithContext(ctx, method, urlStr, body)
 	if err != nil {
 		return nil, fmt.Errorf("new request: %w", err)
 	}
@@ -99,36 +246,168 @@ func (c *Client) newJSONReq(method, urlStr string, data any) (req *http.Request,
 	return
 }
 
-func (c *Client) reqJSON(method, urlStr string, data any) (*http.Response, error) {
-	req, err := c.newJSONReq(method, urlStr, data)
+func (c *Client) reqJSON(ctx context.Context, method, urlStr string, data any) (*http.Response, error) {
+	req, err := c.newJSONReq(ctx, method, urlStr, data)
 	if err != nil {
 		return nil, fmt.Errorf("newJSONReq: %w", err)
 	}
-	return c.Do(req)
+	return c.do(req)
+}
+
+// do send req after applying the request editors
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	if err := c.editRequest(req); err != nil {
+		if req.Body != nil {
+			_ = req.Body.Close()
+		}
+		return nil, err
+	}
+
+	path := reqPathOf(strings.TrimPrefix(req.URL.Path, strings.TrimSuffix(c.BaseURL.Path, "/")))
+	ctx, done := c.metrics.StartRequest(req.Context(), string(path), req.Method)
+	resp, err := c.Do(req.WithContext(ctx))
+	if err != nil {
+		done(0, err)
+		return nil, err
+	}
+	done(resp.StatusCode, nil)
+	return resp, nil
+}
+
+// Close the shared WsMux if any and the idle HTTP connections,
+// c can still be used and a new WsMux is connected on demand
+func (c *Client) Close() error {
+	c.muxMu.Lock()
+	m := c.mux
+	c.mux = nil
+	c.muxMu.Unlock()
+
+	c.CloseIdleConnections()
+	if m == nil {
+		return nil
+	}
+	return m.Close()
 }
 
-type getRespFunc func() (*http.Response, error)
+// Metrics return the metrics set by WithMetrics
+func (c *Client) Metrics() iface.Metrics {
+	return c.metrics
+}
+
+func (c *Client) editRequest(req *http.Request) error {
+	for _, edit := range c.editors {
+		if err := edit(req); err != nil {
+			return fmt.Errorf("edit request: %w", err)
+		}
+	}
+	return nil
+}
+
+type getRespFunc func(ctx context.Context) (*http.Response, error)
 
 func (c *Client) postJSON(path ReqPath, data any) getRespFunc {
-	return func() (*http.Response, error) {
-		return c.reqJSON(http.MethodPost, c.reqURL(path), data)
+	return func(ctx context.Context) (*http.Response, error) {
+		return c.reqJSON(ctx, http.MethodPost, c.reqURL(path), data)
 	}
 }
 
+// getJSON is retried by the retry policy
 func (c *Client) getJSON(path ReqPath, values url.Values) getRespFunc {
-	return func() (*http.Response, error) {
-		urlStr := c.reqURL(path)
-		if len(values) != 0 {
-			urlStr += "?" + values.Encode()
+	return c.getURL(path, c.reqURL(path), values)
+}
+
+// getJSONOnce is like getJSON without retry, for the request is not idempotent
+func (c *Client) getJSONOnce(path ReqPath) getRespFunc {
+	return func(ctx context.Context) (*http.Response, error) {
+		return c.reqJSON(ctx, http.MethodGet, c.reqURL(path), nil)
+	}
+}
+
+// getURL is like getJSON, for the path has parameters in urlStr
+func (c *Client) getURL(path ReqPath, urlStr string, values url.Values) getRespFunc {
+	if len(values) != 0 {
+		urlStr += "?" + values.Encode()
+	}
+	return c.withRetry(path, func(ctx context.Context) (*http.Response, error) {
+		return c.reqJSON(ctx, http.MethodGet, urlStr, nil)
+	})
+}
+
+// sendBytes send content as the raw body
+func (c *Client) sendBytes(method, urlStr string, content []byte) getRespFunc {
+	return func(ctx context.Context) (*http.Response, error) {
+		var body io.Reader
+		if content != nil {
+			body = bytes.NewReader(content)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, urlStr, body)
+		if err != nil {
+			return nil, fmt.Errorf("new request: %w", err)
+		}
+		if content != nil {
+			req.Header.Set("Content-Type", "application/octet-stream")
+		}
+		return c.do(req)
+	}
+}
+
+// postMultipart stream the multipart body written by write,
+// the body is not buffered so the request can not be replayed
+func (c *Client) postMultipart(path ReqPath, write func(mw *multipart.Writer) error) getRespFunc {
+	return func(ctx context.Context) (*http.Response, error) {
+		pr, pw := io.Pipe()
+		mw := multipart.NewWriter(pw)
+		go func() {
+			err := write(mw)
+			if err == nil {
+				err = mw.Close()
+			}
+			_ = pw.CloseWithError(err)
+		}()
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.reqURL(path), pr)
+		if err != nil {
+			_ = pr.Close()
+			return nil, fmt.Errorf("new request: %w", err)
 		}
-		return c.reqJSON(http.MethodGet, urlStr, nil)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		return c.do(req)
 	}
 }
 
+// StatusError is returned for the non-2xx response with empty body,
+// a comfyError.ComfyUIError is returned if the body is not empty
+type StatusError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *StatusError) Error() string {
+	return "comfyui status: " + e.Status
+}
+
 type handleRespFunc func(rd io.Reader, header http.Header) error
 
-func (c *Client) process(run getRespFunc, handle handleRespFunc) error {
-	resp, err := run()
+// decodeJSON decode the response body into v
+func decodeJSON[T any](v *T) handleRespFunc {
+	return func(p io.Reader, _ http.Header) error {
+		if err := json.NewDecoder(p).Decode(v); err != nil {
+			return fmt.Errorf("decode resp: %w", err)
+		}
+		return nil
+	}
+}
+
+// process run the request and handle the response body,
+// the default timeout applies to both when ctx has no deadline
+func (c *Client) process(ctx context.Context, run getRespFunc, handle handleRespFunc) error {
+	if _, ok := ctx.Deadline(); !ok && c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	resp, err := run(ctx)
 	if err != nil {
 		return fmt.Errorf("do request: %w", err)
 	}
@@ -137,16 +416,16 @@ func (c *Client) process(run getRespFunc, handle handleRespFunc) error {
 		_ = resp.Body.Close()
 	}()
 
-	if resp.StatusCode != http.StatusOK {
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		errMsg, err := io.ReadAll(resp.Body)
 		if err != nil {
 			return fmt.Errorf("read error: %w", err)
 		}
 		// empty body, use status as error
 		if len(errMsg) == 0 {
-			return fmt.Errorf("comfyui status: %s", resp.Status)
+			return &StatusError{StatusCode: resp.StatusCode, Status: resp.Status}
 		}
-		return comfyError.ComfyUIError{Message: json.RawMessage(errMsg)}
+		return comfyError.ComfyUIError{Message: json.RawMessage(errMsg), StatusCode: resp.StatusCode}
 	}
 
 	if handle == nil {
@@ -1,12 +1,12 @@
 package comfyui
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
-	"io"
-	"net/http"
 	"net/url"
+	"strings"
 
+	comfyError "github.com/sko00o/comfyui-go/error"
 	"github.com/sko00o/comfyui-go/ws/message"
 )
 
@@ -15,21 +15,23 @@ type ReqPath string
 const (
 	// Core API
 	// ref: https://docs.comfy.org/development/comfyui-server/comms_routes
-	ReqPathPrompt      ReqPath = "/api/prompt"
-	ReqPathHistory     ReqPath = "/api/history"
-	ReqPathView        ReqPath = "/api/view"
-	ReqPathSystemStats ReqPath = "/api/system_stats"
-	//ReqPathViewMetadata ReqPath = "/view_metadata"
-	//ReqPathEmbeddings   ReqPath = "/embeddings"
-	//ReqPathExtensions   ReqPath = "/extensions"
-	//ReqPathInterrupt    ReqPath = "/interrupt"
-	//ReqPathQueue        ReqPath = "/queue"
-	//ReqPathObjectInfo   ReqPath = "/object_info"
-	//ReqPathUploadImage  ReqPath = "/upload/image"
-	//ReqPathUploadMask   ReqPath = "/upload/mask"
-	//ReqPathQueue        ReqPath = "/queue"
-	//ReqPathInterrupt    ReqPath = "/interrupt"
-	//ReqPathFree         ReqPath = "/free"
+	ReqPathPrompt       ReqPath = "/api/prompt"
+	ReqPathHistory      ReqPath = "/api/history"
+	ReqPathView         ReqPath = "/api/view"
+	ReqPathSystemStats  ReqPath = "/api/system_stats"
+	ReqPathQueue        ReqPath = "/api/queue"
+	ReqPathInterrupt    ReqPath = "/api/interrupt"
+	ReqPathFree         ReqPath = "/api/free"
+	ReqPathUploadImage  ReqPath = "/api/upload/image"
+	ReqPathUploadMask   ReqPath = "/api/upload/mask"
+	ReqPathObjectInfo   ReqPath = "/api/object_info"
+	ReqPathEmbeddings   ReqPath = "/api/embeddings"
+	ReqPathExtensions   ReqPath = "/api/extensions"
+	ReqPathModels       ReqPath = "/api/models"
+	ReqPathUserData     ReqPath = "/api/userdata"
+	ReqPathUsers        ReqPath = "/api/users"
+	ReqPathSettings     ReqPath = "/api/settings"
+	ReqPathViewMetadata ReqPath = "/api/view_metadata"
 
 	// API in VHS
 	ReqPathViewVideo ReqPath = "/api/vhs/viewvideo"
@@ -38,21 +40,46 @@ const (
 	ReqPathReboot ReqPath = "/api/manager/reboot"
 )
 
+// reqPaths label the requests in metrics
+var reqPaths = []ReqPath{
+	ReqPathPrompt, ReqPathHistory, ReqPathView, ReqPathSystemStats, ReqPathQueue,
+	ReqPathInterrupt, ReqPathFree, ReqPathUploadImage, ReqPathUploadMask,
+	ReqPathObjectInfo, ReqPathEmbeddings, ReqPathExtensions, ReqPathModels,
+	ReqPathUserData, ReqPathUsers, ReqPathSettings, ReqPathViewMetadata,
+	ReqPathViewVideo, ReqPathReboot,
+}
+
+// reqPathOf return the ReqPath of urlPath without parameters,
+// e.g.: "/api/history" for "/api/history/{prompt_id}"
+func reqPathOf(urlPath string) ReqPath {
+	for _, p := range reqPaths {
+		if urlPath == string(p) || strings.HasPrefix(urlPath, string(p)+"/") {
+			return p
+		}
+	}
+	return ReqPath(urlPath)
+}
+
 type QueuePromptResp struct {
-	PromptID   string          `json:"prompt_id"`
-	Number     int             `json:"number"`
-	NodeErrors json.RawMessage `json:"node_errors,omitempty"`
+	PromptID string `json:"prompt_id"`
+	Number   int    `json:"number"`
+	// NodeErrors has the nodes failed validation,
+	// the prompt is still queued if other outputs are valid
+	NodeErrors comfyError.NodeErrors `json:"node_errors,omitempty"`
 }
 
 // Prompt submit a prompt to the queue
 func (c *Client) Prompt(data map[string]any) (*QueuePromptResp, error) {
+	return c.PromptContext(context.Background(), data)
+}
+
+func (c *Client) PromptContext(ctx context.Context, data map[string]any) (*QueuePromptResp, error) {
+	run := c.postJSON(ReqPathPrompt, data)
+	if c.retry.RetryPrompt {
+		run = c.withRetry(ReqPathPrompt, run)
+	}
 	var respContent QueuePromptResp
-	if err := c.process(c.postJSON(ReqPathPrompt, data), func(p io.Reader, _ http.Header) error {
-		if err := json.NewDecoder(p).Decode(&respContent); err != nil {
-			return fmt.Errorf("decode resp: %w", err)
-		}
-		return nil
-	}); err != nil {
+	if err := c.process(ctx, run, decodeJSON(&respContent)); err != nil {
 		return nil, fmt.Errorf("process: %w", err)
 	}
 
@@ -69,13 +96,12 @@ type ExecInfo struct {
 
 // GetPrompt retrieve current status
 func (c *Client) GetPrompt() (*GetPromptResp, error) {
+	return c.GetPromptContext(context.Background())
+}
+
+func (c *Client) GetPromptContext(ctx context.Context) (*GetPromptResp, error) {
 	var resp GetPromptResp
-	if err := c.process(c.getJSON(ReqPathPrompt, nil), func(p io.Reader, _ http.Header) error {
-		if err := json.NewDecoder(p).Decode(&resp); err != nil {
-			return fmt.Errorf("decode resp: %w", err)
-		}
-		return nil
-	}); err != nil {
+	if err := c.process(ctx, c.getJSON(ReqPathPrompt, nil), decodeJSON(&resp)); err != nil {
 		return nil, fmt.Errorf("process: %w", err)
 	}
 
@@ -83,22 +109,67 @@ func (c *Client) GetPrompt() (*GetPromptResp, error) {
 }
 
 func (c *Client) GetView(f message.FileInfo, handle handleRespFunc) error {
+	return c.GetViewContext(context.Background(), f, handle)
+}
+
+func (c *Client) GetViewContext(ctx context.Context, f message.FileInfo, handle handleRespFunc) error {
 	params := url.Values{}
 	params.Add("filename", f.Filename)
 	params.Add("subfolder", f.Subfolder)
 	params.Add("type", f.Type)
-	return c.process(c.getJSON(ReqPathView, params), handle)
+	return c.process(ctx, c.getJSON(ReqPathView, params), handle)
 }
 
 // always return .webm in this response
 func (c *Client) GetViewVideo(f message.FileInfo, handle handleRespFunc) error {
+	return c.GetViewVideoContext(context.Background(), f, handle)
+}
+
+func (c *Client) GetViewVideoContext(ctx context.Context, f message.FileInfo, handle handleRespFunc) error {
 	params := url.Values{}
 	params.Add("filename", f.Filename)
 	params.Add("subfolder", f.Subfolder)
 	params.Add("type", f.Type)
-	return c.process(c.getJSON(ReqPathViewVideo, params), handle)
+	return c.process(ctx, c.getJSON(ReqPathViewVideo, params), handle)
 }
 
 func (c *Client) Reboot() error {
-	return c.process(c.getJSON(ReqPathReboot, nil), nil)
+	return c.RebootContext(context.Background())
+}
+
+// RebootContext is never retried, the retry may reboot the restarting server again
+func (c *Client) RebootContext(ctx context.Context) error {
+	return c.process(ctx, c.getJSONOnce(ReqPathReboot), nil)
+}
+
+type interruptReq struct {
+	PromptID string `json:"prompt_id,omitempty"`
+}
+
+// Interrupt stop the execution of promptID if it is running,
+// an empty promptID interrupts whatever is running now
+func (c *Client) Interrupt(promptID string) error {
+	return c.InterruptContext(context.Background(), promptID)
+}
+
+func (c *Client) InterruptContext(ctx context.Context, promptID string) error {
+	return c.process(ctx, c.postJSON(ReqPathInterrupt, interruptReq{PromptID: promptID}), nil)
+}
+
+type freeReq struct {
+	UnloadModels bool `json:"unload_models"`
+	FreeMemory   bool `json:"free_memory"`
+}
+
+// Free ask ComfyUI to unload models and/or free cached memory,
+// it takes effect when the queue is idle
+func (c *Client) Free(unloadModels, freeMemory bool) error {
+	return c.FreeContext(context.Background(), unloadModels, freeMemory)
+}
+
+func (c *Client) FreeContext(ctx context.Context, unloadModels, freeMemory bool) error {
+	return c.process(ctx, c.postJSON(ReqPathFree, freeReq{
+		UnloadModels: unloadModels,
+		FreeMemory:   freeMemory,
+	}), nil)
 }
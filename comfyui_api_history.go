@@ -1,34 +1,94 @@
 package comfyui
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
-	"net/http"
 	"net/url"
 	"strconv"
 
 	"github.com/sko00o/comfyui-go/ws/message"
 )
 
+var ErrHistoryNotFound = errors.New("history not found")
+
 func (c *Client) GetHistory(maxItems int) (HistoryResp, error) {
+	return c.GetHistoryContext(context.Background(), maxItems)
+}
+
+func (c *Client) GetHistoryContext(ctx context.Context, maxItems int) (HistoryResp, error) {
+	return c.GetHistoryPageContext(ctx, -1, maxItems)
+}
+
+// GetHistoryPage retrieve at most maxItems history after skipping offset items,
+// negative offset means the latest maxItems history
+func (c *Client) GetHistoryPage(offset, maxItems int) (HistoryResp, error) {
+	return c.GetHistoryPageContext(context.Background(), offset, maxItems)
+}
+
+func (c *Client) GetHistoryPageContext(ctx context.Context, offset, maxItems int) (HistoryResp, error) {
 	params := url.Values{}
 	if maxItems > 0 {
 		params.Add("max_items", strconv.Itoa(maxItems))
 	}
+	if offset >= 0 {
+		params.Add("offset", strconv.Itoa(offset))
+	}
 	var resp HistoryResp
-	if err := c.process(c.getJSON(ReqPathHistory, params), func(p io.Reader, _ http.Header) error {
-		if err := json.NewDecoder(p).Decode(&resp); err != nil {
-			return fmt.Errorf("decode resp: %w", err)
-		}
-		return nil
-	}); err != nil {
+	if err := c.process(ctx, c.getJSON(ReqPathHistory, params), decodeJSON(&resp)); err != nil {
 		return nil, fmt.Errorf("process: %w", err)
 	}
 
 	return resp, nil
 }
 
+// GetHistoryByID retrieve the history of promptID,
+// return ErrHistoryNotFound if the prompt is not finished or not exists
+func (c *Client) GetHistoryByID(promptID string) (*HistoryObj, error) {
+	return c.GetHistoryByIDContext(context.Background(), promptID)
+}
+
+func (c *Client) GetHistoryByIDContext(ctx context.Context, promptID string) (*HistoryObj, error) {
+	var resp HistoryResp
+	urlStr := c.reqURL(ReqPathHistory) + "/" + url.PathEscape(promptID)
+	if err := c.process(ctx, c.getURL(ReqPathHistory, urlStr, nil), decodeJSON(&resp)); err != nil {
+		return nil, fmt.Errorf("process: %w", err)
+	}
+
+	obj, ok := resp[promptID]
+	if !ok {
+		return nil, ErrHistoryNotFound
+	}
+	return &obj, nil
+}
+
+type historyReq struct {
+	Delete []string `json:"delete,omitempty"`
+	Clear  bool     `json:"clear,omitempty"`
+}
+
+// DeleteHistory remove the history of promptIDs
+func (c *Client) DeleteHistory(promptIDs ...string) error {
+	return c.DeleteHistoryContext(context.Background(), promptIDs...)
+}
+
+func (c *Client) DeleteHistoryContext(ctx context.Context, promptIDs ...string) error {
+	if len(promptIDs) == 0 {
+		return nil
+	}
+	return c.process(ctx, c.postJSON(ReqPathHistory, historyReq{Delete: promptIDs}), nil)
+}
+
+// ClearHistory remove all history
+func (c *Client) ClearHistory() error {
+	return c.ClearHistoryContext(context.Background())
+}
+
+func (c *Client) ClearHistoryContext(ctx context.Context) error {
+	return c.process(ctx, c.postJSON(ReqPathHistory, historyReq{Clear: true}), nil)
+}
+
 // HistoryResp has prompt_id in keys
 type HistoryResp map[string]HistoryObj
 
@@ -80,6 +140,25 @@ type StatusObj struct {
 	Messages  []MessageObj `json:"messages"`
 }
 
+const (
+	StatusStrSuccess = "success"
+	StatusStrError   = "error"
+)
+
+func (o StatusObj) IsError() bool {
+	return o.StatusStr == StatusStrError
+}
+
+// IsInterrupted report whether the prompt is interrupted, it is also an error
+func (o StatusObj) IsInterrupted() bool {
+	for _, m := range o.Messages {
+		if m.Type == message.ExecutionInterrupted {
+			return true
+		}
+	}
+	return false
+}
+
 type MessageObj message.Message
 
 func (o *MessageObj) UnmarshalJSON(p []byte) error {
@@ -2,9 +2,12 @@ package comfyui
 
 import (
 	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"github.com/sko00o/comfyui-go/ws/message"
 )
@@ -160,3 +163,51 @@ func TestPromptObj_UnmarshalJSON(t *testing.T) {
 		})
 	}
 }
+
+func TestClient_History(t *testing.T) {
+	var gotQuery []string
+	var gotBody []map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost:
+			var body map[string]any
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			gotBody = append(gotBody, body)
+		case r.URL.Path == string(ReqPathHistory)+"/p1":
+			_, _ = w.Write([]byte(`{"p1":{"outputs":{"9":{"images":[]}},"prompt":[1,"p1",{},{},["9"]],"status":{"completed":true,"status_str":"success","messages":[]}}}`))
+		case r.URL.Path == string(ReqPathHistory):
+			gotQuery = append(gotQuery, r.URL.RawQuery)
+			_, _ = w.Write([]byte(`{}`))
+		default:
+			_, _ = w.Write([]byte(`{}`))
+		}
+	}))
+	defer srv.Close()
+
+	c, err := New(Config{Endpoint: srv.URL})
+	require.NoError(t, err)
+
+	obj, err := c.GetHistoryByID("p1")
+	require.NoError(t, err)
+	assert.True(t, obj.Status.Completed)
+	assert.False(t, obj.Status.IsError())
+	assert.False(t, obj.Status.IsInterrupted())
+	assert.Equal(t, "p1", obj.Prompt.PromptID)
+	assert.Contains(t, obj.Outputs, "9")
+
+	_, err = c.GetHistoryByID("p2")
+	assert.ErrorIs(t, err, ErrHistoryNotFound)
+
+	_, err = c.GetHistory(10)
+	require.NoError(t, err)
+	_, err = c.GetHistoryPage(20, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"max_items=10", "max_items=10&offset=20"}, gotQuery)
+
+	require.NoError(t, c.DeleteHistory("p1"))
+	require.NoError(t, c.ClearHistory())
+	assert.Equal(t, []map[string]any{
+		{"delete": []any{"p1"}},
+		{"clear": true},
+	}, gotBody)
+}
@@ -0,0 +1,90 @@
+package comfyui
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// common model folders in ComfyUI
+const (
+	ModelFolderCheckpoints   = "checkpoints"
+	ModelFolderLoras         = "loras"
+	ModelFolderVAE           = "vae"
+	ModelFolderDiffusion     = "diffusion_models"
+	ModelFolderTextEncoders  = "text_encoders"
+	ModelFolderControlNet    = "controlnet"
+	ModelFolderUpscaleModels = "upscale_models"
+	ModelFolderEmbeddings    = "embeddings"
+)
+
+// Embeddings retrieve the embedding names without extension
+func (c *Client) Embeddings() ([]string, error) {
+	return c.EmbeddingsContext(context.Background())
+}
+
+func (c *Client) EmbeddingsContext(ctx context.Context) ([]string, error) {
+	return c.getStrings(ctx, c.getJSON(ReqPathEmbeddings, nil))
+}
+
+// Extensions retrieve the web extension script paths
+func (c *Client) Extensions() ([]string, error) {
+	return c.ExtensionsContext(context.Background())
+}
+
+func (c *Client) ExtensionsContext(ctx context.Context) ([]string, error) {
+	return c.getStrings(ctx, c.getJSON(ReqPathExtensions, nil))
+}
+
+// ModelFolders retrieve the model folder names, e.g.: "checkpoints", "loras"
+func (c *Client) ModelFolders() ([]string, error) {
+	return c.ModelFoldersContext(context.Background())
+}
+
+func (c *Client) ModelFoldersContext(ctx context.Context) ([]string, error) {
+	return c.getStrings(ctx, c.getJSON(ReqPathModels, nil))
+}
+
+// Models retrieve the model filenames in folder,
+// the filename in subfolder is joined by OS path separator of ComfyUI
+func (c *Client) Models(folder string) ([]string, error) {
+	return c.ModelsContext(context.Background(), folder)
+}
+
+func (c *Client) ModelsContext(ctx context.Context, folder string) ([]string, error) {
+	urlStr := c.reqURL(ReqPathModels) + "/" + url.PathEscape(folder)
+	return c.getStrings(ctx, c.getURL(ReqPathModels, urlStr, nil))
+}
+
+// HasModel reports whether name exists in folder,
+// path separators are ignored in comparing
+func (c *Client) HasModel(folder, name string) (bool, error) {
+	return c.HasModelContext(context.Background(), folder, name)
+}
+
+func (c *Client) HasModelContext(ctx context.Context, folder, name string) (bool, error) {
+	models, err := c.ModelsContext(ctx, folder)
+	if err != nil {
+		return false, err
+	}
+	name = normalizeModelName(name)
+	for _, m := range models {
+		if normalizeModelName(m) == name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func normalizeModelName(name string) string {
+	return strings.ReplaceAll(name, `\`, "/")
+}
+
+func (c *Client) getStrings(ctx context.Context, run getRespFunc) ([]string, error) {
+	var resp []string
+	if err := c.process(ctx, run, decodeJSON(&resp)); err != nil {
+		return nil, fmt.Errorf("process: %w", err)
+	}
+	return resp, nil
+}
@@ -0,0 +1,51 @@
+package comfyui
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_Models(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case string(ReqPathModels):
+			_, _ = w.Write([]byte(`["checkpoints","loras"]`))
+		case string(ReqPathModels) + "/loras":
+			_, _ = w.Write([]byte(`["detail.safetensors","flux\\realism.safetensors"]`))
+		case string(ReqPathEmbeddings):
+			_, _ = w.Write([]byte(`["easynegative"]`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	c, err := New(Config{Endpoint: srv.URL})
+	require.NoError(t, err)
+
+	folders, err := c.ModelFolders()
+	require.NoError(t, err)
+	assert.Equal(t, []string{ModelFolderCheckpoints, ModelFolderLoras}, folders)
+
+	embeddings, err := c.Embeddings()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"easynegative"}, embeddings)
+
+	for name, want := range map[string]bool{
+		"detail.safetensors":        true,
+		"flux/realism.safetensors":  true,
+		"flux\\realism.safetensors": true,
+		"missing.safetensors":       false,
+	} {
+		got, err := c.HasModel(ModelFolderLoras, name)
+		require.NoError(t, err)
+		assert.Equal(t, want, got, name)
+	}
+
+	_, err = c.HasModel("unknown", "a.safetensors")
+	assert.Error(t, err)
+}
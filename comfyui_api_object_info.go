@@ -0,0 +1,139 @@
+package comfyui
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+)
+
+// NodeInfos has node type in keys
+type NodeInfos map[string]*NodeInfo
+
+// NodeInfo is the definition of a node type from object_info
+type NodeInfo struct {
+	Input          NodeInputs     `json:"input"`
+	InputOrder     NodeInputOrder `json:"input_order"`
+	Output         []OutputType   `json:"output"`
+	OutputIsList   []bool         `json:"output_is_list"`
+	OutputName     []string       `json:"output_name"`
+	OutputTooltips []string       `json:"output_tooltips,omitempty"`
+	Name           string         `json:"name"`
+	DisplayName    string         `json:"display_name"`
+	Description    string         `json:"description"`
+	PythonModule   string         `json:"python_module"`
+	Category       string         `json:"category"`
+	OutputNode     bool           `json:"output_node"`
+	Deprecated     bool           `json:"deprecated,omitempty"`
+	Experimental   bool           `json:"experimental,omitempty"`
+}
+
+type NodeInputs struct {
+	Required map[string]InputDef `json:"required"`
+	Optional map[string]InputDef `json:"optional"`
+	Hidden   map[string]any      `json:"hidden,omitempty"`
+}
+
+type NodeInputOrder struct {
+	Required []string `json:"required"`
+	Optional []string `json:"optional"`
+	Hidden   []string `json:"hidden,omitempty"`
+}
+
+// InputDef is the definition of an input: [type, {options}],
+// type is a string like "INT", or a list of choices for combo
+type InputDef []any
+
+// Type return the input type, "COMBO" for the list of choices
+func (d InputDef) Type() string {
+	if len(d) == 0 {
+		return ""
+	}
+	switch v := d[0].(type) {
+	case string:
+		return v
+	case []any:
+		return OutputTypeCombo
+	}
+	return ""
+}
+
+// Choices return the choices of a combo input
+func (d InputDef) Choices() []string {
+	if len(d) == 0 {
+		return nil
+	}
+	var values []any
+	switch v := d[0].(type) {
+	case []any:
+		values = v
+	case string:
+		// new style combo: ["COMBO", {"options": [...]}]
+		if v != OutputTypeCombo {
+			return nil
+		}
+		if opts := d.Options(); opts != nil {
+			values, _ = opts["options"].([]any)
+		}
+	}
+	choices := make([]string, 0, len(values))
+	for _, v := range values {
+		if s, ok := v.(string); ok {
+			choices = append(choices, s)
+		}
+	}
+	return choices
+}
+
+// Options return the options of an input, e.g.: default, min, max, tooltip
+func (d InputDef) Options() map[string]any {
+	if len(d) < 2 {
+		return nil
+	}
+	opts, _ := d[1].(map[string]any)
+	return opts
+}
+
+const OutputTypeCombo = "COMBO"
+
+// OutputType is the type of node output, "COMBO" for the list of choices
+type OutputType string
+
+func (t *OutputType) UnmarshalJSON(p []byte) error {
+	var s string
+	if err := json.Unmarshal(p, &s); err == nil {
+		*t = OutputType(s)
+		return nil
+	}
+	var list []any
+	if err := json.Unmarshal(p, &list); err != nil {
+		return fmt.Errorf("output type is neither string nor list: %s", p)
+	}
+	*t = OutputTypeCombo
+	return nil
+}
+
+// ObjectInfo retrieve definitions of all node types
+func (c *Client) ObjectInfo(ctx context.Context) (NodeInfos, error) {
+	var resp NodeInfos
+	if err := c.process(ctx, c.getJSON(ReqPathObjectInfo, nil), decodeJSON(&resp)); err != nil {
+		return nil, fmt.Errorf("process: %w", err)
+	}
+
+	return resp, nil
+}
+
+// NodeInfo retrieve the definition of nodeType
+func (c *Client) NodeInfo(ctx context.Context, nodeType string) (*NodeInfo, error) {
+	var resp NodeInfos
+	urlStr := c.reqURL(ReqPathObjectInfo) + "/" + url.PathEscape(nodeType)
+	if err := c.process(ctx, c.getURL(ReqPathObjectInfo, urlStr, nil), decodeJSON(&resp)); err != nil {
+		return nil, fmt.Errorf("process: %w", err)
+	}
+
+	info, ok := resp[nodeType]
+	if !ok || info == nil {
+		return nil, fmt.Errorf("node info for %q not found", nodeType)
+	}
+	return info, nil
+}
@@ -0,0 +1,84 @@
+package comfyui
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_NodeInfo(t *testing.T) {
+	content, err := os.ReadFile("graph/test/object_info/CheckpointLoaderSimple.json")
+	require.NoError(t, err)
+
+	// ComfyUI behind a reverse proxy at /comfy/
+	srv := httptest.NewServer(http.StripPrefix("/comfy", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case string(ReqPathObjectInfo), string(ReqPathObjectInfo) + "/CheckpointLoaderSimple":
+			_, _ = w.Write(content)
+		default:
+			_, _ = w.Write([]byte(`{}`))
+		}
+	})))
+	defer srv.Close()
+
+	c, err := New(Config{Endpoint: srv.URL + "/comfy/"})
+	require.NoError(t, err)
+
+	infos, err := c.ObjectInfo(context.Background())
+	require.NoError(t, err)
+	assert.Contains(t, infos, "CheckpointLoaderSimple")
+
+	info, err := c.NodeInfo(context.Background(), "CheckpointLoaderSimple")
+	require.NoError(t, err)
+	assert.Equal(t, []OutputType{"MODEL", "CLIP", "VAE"}, info.Output)
+	assert.Equal(t, []bool{false, false, false}, info.OutputIsList)
+	assert.Equal(t, "loaders", info.Category)
+	assert.False(t, info.OutputNode)
+	assert.Equal(t, OutputTypeCombo, info.Input.Required["ckpt_name"].Type())
+
+	_, err = c.NodeInfo(context.Background(), "NotExists")
+	assert.Error(t, err)
+}
+
+func TestInputDef(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       string
+		wantType    string
+		wantChoices []string
+	}{
+		{
+			name:     "int",
+			input:    `["INT", {"default": 20, "min": 1}]`,
+			wantType: "INT",
+		},
+		{
+			name:        "legacy combo",
+			input:       `[["euler", "ddim"], {"tooltip": "sampler"}]`,
+			wantType:    OutputTypeCombo,
+			wantChoices: []string{"euler", "ddim"},
+		},
+		{
+			name:        "new combo",
+			input:       `["COMBO", {"options": ["a.safetensors", "b.safetensors"]}]`,
+			wantType:    OutputTypeCombo,
+			wantChoices: []string{"a.safetensors", "b.safetensors"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var d InputDef
+			require.NoError(t, json.Unmarshal([]byte(tt.input), &d))
+			assert.Equal(t, tt.wantType, d.Type())
+			if tt.wantChoices != nil {
+				assert.Equal(t, tt.wantChoices, d.Choices())
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package comfyui
+
+import (
+	"context"
+	"fmt"
+)
+
+// QueueResp is the current state of the ComfyUI execution queue
+type QueueResp struct {
+	Running []PromptObj `json:"queue_running"`
+	Pending []PromptObj `json:"queue_pending"`
+}
+
+// Has reports whether promptID is running or pending in the queue
+func (q *QueueResp) Has(promptID string) bool {
+	return q.IsRunning(promptID) || q.IsPending(promptID)
+}
+
+func (q *QueueResp) IsRunning(promptID string) bool {
+	for _, p := range q.Running {
+		if p.PromptID == promptID {
+			return true
+		}
+	}
+	return false
+}
+
+func (q *QueueResp) IsPending(promptID string) bool {
+	for _, p := range q.Pending {
+		if p.PromptID == promptID {
+			return true
+		}
+	}
+	return false
+}
+
+// GetQueue retrieve the running and pending prompts
+func (c *Client) GetQueue() (*QueueResp, error) {
+	return c.GetQueueContext(context.Background())
+}
+
+func (c *Client) GetQueueContext(ctx context.Context) (*QueueResp, error) {
+	var resp QueueResp
+	if err := c.process(ctx, c.getJSON(ReqPathQueue, nil), decodeJSON(&resp)); err != nil {
+		return nil, fmt.Errorf("process: %w", err)
+	}
+
+	return &resp, nil
+}
+
+type queueReq struct {
+	Delete []string `json:"delete,omitempty"`
+	Clear  bool     `json:"clear,omitempty"`
+}
+
+// DeleteFromQueue remove pending prompts from the queue,
+// running prompts are not affected, use Interrupt instead
+func (c *Client) DeleteFromQueue(promptIDs ...string) error {
+	return c.DeleteFromQueueContext(context.Background(), promptIDs...)
+}
+
+func (c *Client) DeleteFromQueueContext(ctx context.Context, promptIDs ...string) error {
+	if len(promptIDs) == 0 {
+		return nil
+	}
+	return c.process(ctx, c.postJSON(ReqPathQueue, queueReq{Delete: promptIDs}), nil)
+}
+
+// ClearQueue remove all pending prompts from the queue
+func (c *Client) ClearQueue() error {
+	return c.ClearQueueContext(context.Background())
+}
+
+func (c *Client) ClearQueueContext(ctx context.Context) error {
+	return c.process(ctx, c.postJSON(ReqPathQueue, queueReq{Clear: true}), nil)
+}
@@ -0,0 +1,61 @@
+package comfyui
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnmarshalQueueResp(t *testing.T) {
+	jsonStr := `{
+  "queue_running": [
+    [3, "8c4a5e55-8d36-4b8b-a5c4-0dd1e4a6cb1d", {"9": {"inputs": {}, "class_type": "PreviewImage"}}, {"client_id": "c1"}, ["9"]]
+  ],
+  "queue_pending": [
+    [4, "1f1c3e2e-37ec-4a65-8c38-5c8d3b2a4f10", {}, {}, ["9"]],
+    [5, "a1d9ad0c-5d3b-4f0c-9b5d-1ab8c5f6e2f7", {}, {}, ["9"]]
+  ]
+}`
+
+	var got QueueResp
+	require.NoError(t, json.Unmarshal([]byte(jsonStr), &got))
+
+	assert.Len(t, got.Running, 1)
+	assert.Len(t, got.Pending, 2)
+	assert.Equal(t, uint64(3), got.Running[0].Num)
+	assert.Equal(t, []string{"9"}, got.Running[0].OutputNodeIDs)
+
+	assert.True(t, got.IsRunning("8c4a5e55-8d36-4b8b-a5c4-0dd1e4a6cb1d"))
+	assert.True(t, got.IsPending("a1d9ad0c-5d3b-4f0c-9b5d-1ab8c5f6e2f7"))
+	assert.True(t, got.Has("1f1c3e2e-37ec-4a65-8c38-5c8d3b2a4f10"))
+	assert.False(t, got.Has("unknown"))
+}
+
+func TestClient_QueueEdit(t *testing.T) {
+	var gotBody []map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, string(ReqPathQueue), r.URL.Path)
+		assert.Equal(t, http.MethodPost, r.Method)
+		var body map[string]any
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		gotBody = append(gotBody, body)
+	}))
+	defer srv.Close()
+
+	c, err := New(Config{Endpoint: srv.URL})
+	require.NoError(t, err)
+
+	require.NoError(t, c.DeleteFromQueue("a", "b"))
+	require.NoError(t, c.ClearQueue())
+	// no request for empty ids
+	require.NoError(t, c.DeleteFromQueue())
+
+	assert.Equal(t, []map[string]any{
+		{"delete": []any{"a", "b"}},
+		{"clear": true},
+	}, gotBody)
+}
@@ -1,10 +1,8 @@
 package comfyui
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
-	"io"
-	"net/http"
 )
 
 type StatsResp struct {
@@ -34,13 +32,12 @@ type DeviceInfo struct {
 }
 
 func (c *Client) Stats() (*StatsResp, error) {
+	return c.StatsContext(context.Background())
+}
+
+func (c *Client) StatsContext(ctx context.Context) (*StatsResp, error) {
 	var resp StatsResp
-	if err := c.process(c.getJSON(ReqPathSystemStats, nil), func(p io.Reader, _ http.Header) error {
-		if err := json.NewDecoder(p).Decode(&resp); err != nil {
-			return fmt.Errorf("decode resp: %w", err)
-		}
-		return nil
-	}); err != nil {
+	if err := c.process(ctx, c.getJSON(ReqPathSystemStats, nil), decodeJSON(&resp)); err != nil {
 		return nil, fmt.Errorf("process: %w", err)
 	}
 
@@ -0,0 +1,33 @@
+package comfyui
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_InterruptAndFree(t *testing.T) {
+	gotBody := make(map[string]map[string]any)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		var body map[string]any
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		gotBody[r.URL.Path] = body
+	}))
+	defer srv.Close()
+
+	c, err := New(Config{Endpoint: srv.URL})
+	require.NoError(t, err)
+
+	require.NoError(t, c.Interrupt("p1"))
+	require.NoError(t, c.Free(true, false))
+
+	assert.Equal(t, map[string]map[string]any{
+		string(ReqPathInterrupt): {"prompt_id": "p1"},
+		string(ReqPathFree):      {"unload_models": true, "free_memory": false},
+	}, gotBody)
+}
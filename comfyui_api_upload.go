@@ -0,0 +1,103 @@
+package comfyui
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"strconv"
+
+	"github.com/sko00o/comfyui-go/ws/message"
+)
+
+type UploadOptions struct {
+	// Filename is the name saved in ComfyUI, required
+	Filename string
+	// Subfolder under the target directory, optional
+	Subfolder string
+	// Type is one of "input", "temp" and "output", ComfyUI uses "input" if empty
+	Type string
+	// Overwrite the existing file, otherwise ComfyUI renames the new one
+	Overwrite bool
+}
+
+func (o UploadOptions) writeFields(mw *multipart.Writer) error {
+	fields := [][2]string{
+		{"subfolder", o.Subfolder},
+		{"type", o.Type},
+		{"overwrite", strconv.FormatBool(o.Overwrite)},
+	}
+	for _, f := range fields {
+		if f[1] == "" {
+			continue
+		}
+		if err := mw.WriteField(f[0], f[1]); err != nil {
+			return fmt.Errorf("write field %s: %w", f[0], err)
+		}
+	}
+	return nil
+}
+
+// UploadImage stream image from rd to ComfyUI
+func (c *Client) UploadImage(ctx context.Context, rd io.Reader, opts UploadOptions) (*message.FileInfo, error) {
+	return c.upload(ctx, ReqPathUploadImage, rd, opts, nil)
+}
+
+// UploadMask stream mask from rd to ComfyUI, the alpha channel of mask
+// is applied to the originalRef image which already exists in ComfyUI
+func (c *Client) UploadMask(ctx context.Context, rd io.Reader, opts UploadOptions, originalRef message.FileInfo) (*message.FileInfo, error) {
+	ref, err := json.Marshal(map[string]string{
+		"filename":  originalRef.Filename,
+		"subfolder": originalRef.Subfolder,
+		"type":      originalRef.Type,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("marshal original_ref: %w", err)
+	}
+	return c.upload(ctx, ReqPathUploadMask, rd, opts, map[string]string{
+		"original_ref": string(ref),
+	})
+}
+
+type uploadResp struct {
+	Name      string `json:"name"`
+	Subfolder string `json:"subfolder"`
+	Type      string `json:"type"`
+}
+
+func (c *Client) upload(ctx context.Context, path ReqPath, rd io.Reader, opts UploadOptions, extra map[string]string) (*message.FileInfo, error) {
+	if opts.Filename == "" {
+		return nil, fmt.Errorf("filename is required")
+	}
+
+	var resp uploadResp
+	if err := c.process(ctx, c.postMultipart(path, func(mw *multipart.Writer) error {
+		if err := opts.writeFields(mw); err != nil {
+			return err
+		}
+		for k, v := range extra {
+			if err := mw.WriteField(k, v); err != nil {
+				return fmt.Errorf("write field %s: %w", k, err)
+			}
+		}
+		// ComfyUI reads both image and mask from the "image" field
+		part, err := mw.CreateFormFile("image", opts.Filename)
+		if err != nil {
+			return fmt.Errorf("create form file: %w", err)
+		}
+		if _, err := io.Copy(part, rd); err != nil {
+			return fmt.Errorf("copy file: %w", err)
+		}
+		return nil
+	}), decodeJSON(&resp)); err != nil {
+		return nil, fmt.Errorf("process: %w", err)
+	}
+
+	return &message.FileInfo{
+		Filename:  resp.Name,
+		Subfolder: resp.Subfolder,
+		Type:      resp.Type,
+		Raw:       map[string]any{},
+	}, nil
+}
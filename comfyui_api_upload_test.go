@@ -0,0 +1,78 @@
+package comfyui
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_Upload(t *testing.T) {
+	// the form is checked after the call returns, not in the handler goroutine
+	var (
+		gotPath    string
+		gotContent string
+		gotForm    map[string][]string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f, fh, err := r.FormFile("image")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		content, err := io.ReadAll(f)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		gotPath = r.URL.Path
+		gotContent = string(content)
+		gotForm = r.MultipartForm.Value
+
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"name":      fh.Filename,
+			"subfolder": r.FormValue("subfolder"),
+			"type":      "input",
+		})
+	}))
+	defer srv.Close()
+
+	c, err := New(Config{Endpoint: srv.URL})
+	require.NoError(t, err)
+
+	opts := UploadOptions{Filename: "a.png", Subfolder: "sub", Overwrite: true}
+	got, err := c.UploadImage(context.Background(), strings.NewReader("fake-png"), opts)
+	require.NoError(t, err)
+	assert.Equal(t, string(ReqPathUploadImage), gotPath)
+	assert.Equal(t, "fake-png", gotContent)
+	assert.Equal(t, []string{"true"}, gotForm["overwrite"])
+	assert.Equal(t, "a.png", got.Filename)
+	assert.Equal(t, "sub", got.Subfolder)
+	assert.Equal(t, "input", got.Type)
+
+	opts = UploadOptions{Filename: "mask.png", Subfolder: "sub"}
+	got, err = c.UploadMask(context.Background(), strings.NewReader("fake-png"), opts, *got)
+	require.NoError(t, err)
+	assert.Equal(t, string(ReqPathUploadMask), gotPath)
+	assert.Equal(t, "fake-png", gotContent)
+	require.Len(t, gotForm["original_ref"], 1)
+	assert.JSONEq(t, `{"filename":"a.png","subfolder":"sub","type":"input"}`, gotForm["original_ref"][0])
+	assert.Equal(t, "mask.png", got.Filename)
+
+	p, err := json.Marshal(got)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"filename":"mask.png","subfolder":"sub","type":"input"}`, string(p))
+
+	_, err = c.UploadImage(context.Background(), strings.NewReader(""), UploadOptions{})
+	assert.Error(t, err)
+}
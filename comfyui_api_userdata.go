@@ -0,0 +1,217 @@
+package comfyui
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// UserDataDirWorkflows is where the web UI saves workflows
+const UserDataDirWorkflows = "workflows"
+
+// HeaderComfyUser select the user in multi-user mode
+const HeaderComfyUser = "Comfy-User"
+
+// WithComfyUser select the user for userdata and settings API
+func WithComfyUser(userID string) Option {
+	return WithHeader(HeaderComfyUser, userID)
+}
+
+type UserDataFile struct {
+	// Path is relative to the listed dir, or to the user dir for write and move
+	Path     string  `json:"path"`
+	Size     int64   `json:"size"`
+	Modified float64 `json:"modified"`
+	Created  float64 `json:"created,omitempty"`
+}
+
+// ModTime convert the modified unix seconds to time
+func (f UserDataFile) ModTime() time.Time {
+	sec, frac := math.Modf(f.Modified)
+	return time.Unix(int64(sec), int64(frac*1e9))
+}
+
+func (c *Client) userDataURL(file string, elem ...string) string {
+	urlStr := c.reqURL(ReqPathUserData) + "/" + url.PathEscape(file)
+	for _, e := range elem {
+		urlStr += "/" + url.PathEscape(e)
+	}
+	return urlStr
+}
+
+// ListUserData list files in dir of user directory
+func (c *Client) ListUserData(dir string, recurse bool) ([]UserDataFile, error) {
+	return c.ListUserDataContext(context.Background(), dir, recurse)
+}
+
+func (c *Client) ListUserDataContext(ctx context.Context, dir string, recurse bool) ([]UserDataFile, error) {
+	params := url.Values{}
+	params.Add("dir", dir)
+	params.Add("recurse", strconv.FormatBool(recurse))
+	params.Add("full_info", "true")
+	var resp []UserDataFile
+	if err := c.process(ctx, c.getJSON(ReqPathUserData, params), decodeJSON(&resp)); err != nil {
+		return nil, fmt.Errorf("process: %w", err)
+	}
+	return resp, nil
+}
+
+// ReadUserData read file content from user directory
+func (c *Client) ReadUserData(file string) ([]byte, error) {
+	return c.ReadUserDataContext(context.Background(), file)
+}
+
+func (c *Client) ReadUserDataContext(ctx context.Context, file string) ([]byte, error) {
+	var content []byte
+	if err := c.process(ctx, c.getURL(ReqPathUserData, c.userDataURL(file), nil), func(p io.Reader, _ http.Header) error {
+		var err error
+		content, err = io.ReadAll(p)
+		return err
+	}); err != nil {
+		return nil, fmt.Errorf("process: %w", err)
+	}
+	return content, nil
+}
+
+// ReadWorkflow read the workflow saved by web UI, name is relative to workflows dir
+func (c *Client) ReadWorkflow(name string) (json.RawMessage, error) {
+	return c.ReadWorkflowContext(context.Background(), name)
+}
+
+func (c *Client) ReadWorkflowContext(ctx context.Context, name string) (json.RawMessage, error) {
+	return c.ReadUserDataContext(ctx, UserDataDirWorkflows+"/"+name)
+}
+
+// WriteUserData write content to file in user directory,
+// ComfyUI returns conflict error if file exists and overwrite is false
+func (c *Client) WriteUserData(file string, content []byte, overwrite bool) (*UserDataFile, error) {
+	return c.WriteUserDataContext(context.Background(), file, content, overwrite)
+}
+
+func (c *Client) WriteUserDataContext(ctx context.Context, file string, content []byte, overwrite bool) (*UserDataFile, error) {
+	params := url.Values{}
+	params.Add("overwrite", strconv.FormatBool(overwrite))
+	params.Add("full_info", "true")
+	urlStr := c.userDataURL(file) + "?" + params.Encode()
+	var resp UserDataFile
+	if err := c.process(ctx, c.sendBytes(http.MethodPost, urlStr, content), decodeJSON(&resp)); err != nil {
+		return nil, fmt.Errorf("process: %w", err)
+	}
+	return &resp, nil
+}
+
+// MoveUserData move file to dest in user directory
+func (c *Client) MoveUserData(file, dest string, overwrite bool) (*UserDataFile, error) {
+	return c.MoveUserDataContext(context.Background(), file, dest, overwrite)
+}
+
+func (c *Client) MoveUserDataContext(ctx context.Context, file, dest string, overwrite bool) (*UserDataFile, error) {
+	params := url.Values{}
+	params.Add("overwrite", strconv.FormatBool(overwrite))
+	params.Add("full_info", "true")
+	urlStr := c.userDataURL(file, "move", dest) + "?" + params.Encode()
+	var resp UserDataFile
+	if err := c.process(ctx, c.sendBytes(http.MethodPost, urlStr, nil), decodeJSON(&resp)); err != nil {
+		return nil, fmt.Errorf("process: %w", err)
+	}
+	return &resp, nil
+}
+
+// DeleteUserData delete file in user directory
+func (c *Client) DeleteUserData(file string) error {
+	return c.DeleteUserDataContext(context.Background(), file)
+}
+
+func (c *Client) DeleteUserDataContext(ctx context.Context, file string) error {
+	return c.process(ctx, c.sendBytes(http.MethodDelete, c.userDataURL(file), nil), nil)
+}
+
+type UsersResp struct {
+	Storage string `json:"storage"`
+	// Migrated is set in single-user mode
+	Migrated bool `json:"migrated,omitempty"`
+	// Users has user id in keys and name in values, set in multi-user mode
+	Users map[string]string `json:"users,omitempty"`
+}
+
+// Users retrieve users in multi-user mode
+func (c *Client) Users() (*UsersResp, error) {
+	return c.UsersContext(context.Background())
+}
+
+func (c *Client) UsersContext(ctx context.Context) (*UsersResp, error) {
+	var resp UsersResp
+	if err := c.process(ctx, c.getJSON(ReqPathUsers, nil), decodeJSON(&resp)); err != nil {
+		return nil, fmt.Errorf("process: %w", err)
+	}
+	return &resp, nil
+}
+
+// CreateUser create user in multi-user mode, return the user id
+func (c *Client) CreateUser(username string) (string, error) {
+	return c.CreateUserContext(context.Background(), username)
+}
+
+func (c *Client) CreateUserContext(ctx context.Context, username string) (string, error) {
+	var userID string
+	if err := c.process(ctx, c.postJSON(ReqPathUsers, map[string]string{
+		"username": username,
+	}), decodeJSON(&userID)); err != nil {
+		return "", fmt.Errorf("process: %w", err)
+	}
+	return userID, nil
+}
+
+// Settings retrieve all settings of user
+func (c *Client) Settings() (map[string]json.RawMessage, error) {
+	return c.SettingsContext(context.Background())
+}
+
+func (c *Client) SettingsContext(ctx context.Context) (map[string]json.RawMessage, error) {
+	var resp map[string]json.RawMessage
+	if err := c.process(ctx, c.getJSON(ReqPathSettings, nil), decodeJSON(&resp)); err != nil {
+		return nil, fmt.Errorf("process: %w", err)
+	}
+	return resp, nil
+}
+
+// Setting retrieve the setting of id, null if not set
+func (c *Client) Setting(id string) (json.RawMessage, error) {
+	return c.SettingContext(context.Background(), id)
+}
+
+func (c *Client) SettingContext(ctx context.Context, id string) (json.RawMessage, error) {
+	var resp json.RawMessage
+	urlStr := c.reqURL(ReqPathSettings) + "/" + url.PathEscape(id)
+	if err := c.process(ctx, c.getURL(ReqPathSettings, urlStr, nil), decodeJSON(&resp)); err != nil {
+		return nil, fmt.Errorf("process: %w", err)
+	}
+	return resp, nil
+}
+
+// SaveSettings merge settings into the settings of user
+func (c *Client) SaveSettings(settings map[string]any) error {
+	return c.SaveSettingsContext(context.Background(), settings)
+}
+
+func (c *Client) SaveSettingsContext(ctx context.Context, settings map[string]any) error {
+	return c.process(ctx, c.postJSON(ReqPathSettings, settings), nil)
+}
+
+// SaveSetting save the setting of id
+func (c *Client) SaveSetting(id string, value any) error {
+	return c.SaveSettingContext(context.Background(), id, value)
+}
+
+func (c *Client) SaveSettingContext(ctx context.Context, id string, value any) error {
+	urlStr := c.reqURL(ReqPathSettings) + "/" + url.PathEscape(id)
+	return c.process(ctx, func(ctx context.Context) (*http.Response, error) {
+		return c.reqJSON(ctx, http.MethodPost, urlStr, value)
+	}, nil)
+}
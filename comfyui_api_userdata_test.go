@@ -0,0 +1,112 @@
+package comfyui
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_UserData(t *testing.T) {
+	files := map[string][]byte{
+		"workflows/a.json": []byte(`{"nodes":[]}`),
+	}
+	settings := map[string]any{"Comfy.Locale": "en"}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/userdata", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "workflows", r.URL.Query().Get("dir"))
+		assert.Equal(t, "true", r.URL.Query().Get("full_info"))
+		var list []UserDataFile
+		for name, content := range files {
+			list = append(list, UserDataFile{Path: name[len("workflows/"):], Size: int64(len(content)), Modified: 1700000000.5})
+		}
+		_ = json.NewEncoder(w).Encode(list)
+	})
+	mux.HandleFunc("GET /api/userdata/{file}", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "bob", r.Header.Get(HeaderComfyUser))
+		content, ok := files[r.PathValue("file")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(content)
+	})
+	mux.HandleFunc("POST /api/userdata/{file}", func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("file")
+		if _, ok := files[name]; ok && r.URL.Query().Get("overwrite") == "false" {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		content, _ := io.ReadAll(r.Body)
+		files[name] = content
+		_ = json.NewEncoder(w).Encode(UserDataFile{Path: name, Size: int64(len(content))})
+	})
+	mux.HandleFunc("POST /api/userdata/{file}/move/{dest}", func(w http.ResponseWriter, r *http.Request) {
+		src, dest := r.PathValue("file"), r.PathValue("dest")
+		files[dest] = files[src]
+		delete(files, src)
+		_ = json.NewEncoder(w).Encode(UserDataFile{Path: dest})
+	})
+	mux.HandleFunc("DELETE /api/userdata/{file}", func(w http.ResponseWriter, r *http.Request) {
+		delete(files, r.PathValue("file"))
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("GET /api/users", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"storage":"server","users":{"bob_1":"bob"}}`))
+	})
+	mux.HandleFunc("GET /api/settings/{id}", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(settings[r.PathValue("id")])
+	})
+	mux.HandleFunc("POST /api/settings/{id}", func(w http.ResponseWriter, r *http.Request) {
+		var v any
+		_ = json.NewDecoder(r.Body).Decode(&v)
+		settings[r.PathValue("id")] = v
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	c, err := New(Config{Endpoint: srv.URL}, WithComfyUser("bob"))
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	list, err := c.ListUserDataContext(ctx, UserDataDirWorkflows, true)
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	assert.Equal(t, "a.json", list[0].Path)
+	assert.Equal(t, int64(1700000000), list[0].ModTime().Unix())
+
+	wf, err := c.ReadWorkflowContext(ctx, "a.json")
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"nodes":[]}`, string(wf))
+
+	_, err = c.WriteUserDataContext(ctx, "workflows/a.json", []byte(`{}`), false)
+	assert.Error(t, err)
+	got, err := c.WriteUserDataContext(ctx, "workflows/b c.json", []byte(`{}`), true)
+	require.NoError(t, err)
+	assert.Equal(t, "workflows/b c.json", got.Path)
+	assert.Equal(t, int64(2), got.Size)
+
+	got, err = c.MoveUserDataContext(ctx, "workflows/b c.json", "workflows/d.json", false)
+	require.NoError(t, err)
+	assert.Equal(t, "workflows/d.json", got.Path)
+	assert.Contains(t, files, "workflows/d.json")
+
+	require.NoError(t, c.DeleteUserDataContext(ctx, "workflows/d.json"))
+	assert.NotContains(t, files, "workflows/d.json")
+	_, err = c.ReadUserDataContext(ctx, "workflows/d.json")
+	assert.Error(t, err)
+
+	users, err := c.Users()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"bob_1": "bob"}, users.Users)
+
+	require.NoError(t, c.SaveSettingContext(ctx, "Comfy.Locale", "zh"))
+	v, err := c.SettingContext(ctx, "Comfy.Locale")
+	require.NoError(t, err)
+	assert.JSONEq(t, `"zh"`, string(v))
+}
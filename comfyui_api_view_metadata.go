@@ -0,0 +1,160 @@
+package comfyui
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// ErrMetadataNotFound is returned if the model is not found,
+// not a .safetensors file, or has no __metadata__ in header
+var ErrMetadataNotFound = errors.New("metadata not found")
+
+// SafetensorsMetadata is the __metadata__ in safetensors header
+type SafetensorsMetadata map[string]string
+
+// BaseModel is the model family a LoRA is trained on
+type BaseModel string
+
+const (
+	BaseModelUnknown BaseModel = ""
+	BaseModelSD15    BaseModel = "sd15"
+	BaseModelSD2     BaseModel = "sd2"
+	BaseModelSDXL    BaseModel = "sdxl"
+	BaseModelSD3     BaseModel = "sd3"
+	BaseModelFlux    BaseModel = "flux"
+)
+
+// Compatible report whether a model of m can be applied to base,
+// unknown base model is always compatible
+func (m BaseModel) Compatible(base BaseModel) bool {
+	return m == BaseModelUnknown || base == BaseModelUnknown || m == base
+}
+
+// BaseModel detect the base model from kohya-ss or modelspec keys
+func (m SafetensorsMetadata) BaseModel() BaseModel {
+	if v := m["modelspec.architecture"]; v != "" {
+		arch, _, _ := strings.Cut(v, "/")
+		switch {
+		case strings.HasPrefix(arch, "stable-diffusion-v1"):
+			return BaseModelSD15
+		case strings.HasPrefix(arch, "stable-diffusion-v2"):
+			return BaseModelSD2
+		case strings.HasPrefix(arch, "stable-diffusion-xl"):
+			return BaseModelSDXL
+		case strings.HasPrefix(arch, "stable-diffusion-3"):
+			return BaseModelSD3
+		case strings.HasPrefix(arch, "flux"):
+			return BaseModelFlux
+		}
+	}
+
+	v := strings.ToLower(m["ss_base_model_version"])
+	switch {
+	case strings.HasPrefix(v, "sd_v1"):
+		return BaseModelSD15
+	case strings.HasPrefix(v, "sd_v2"):
+		return BaseModelSD2
+	case strings.HasPrefix(v, "sdxl"):
+		return BaseModelSDXL
+	case strings.HasPrefix(v, "sd3"):
+		return BaseModelSD3
+	case strings.HasPrefix(v, "flux"):
+		return BaseModelFlux
+	}
+	if m["ss_v2"] == "True" {
+		return BaseModelSD2
+	}
+	if _, ok := m["ss_network_module"]; ok {
+		// old kohya-ss scripts only train SD1.x without version key
+		return BaseModelSD15
+	}
+	return BaseModelUnknown
+}
+
+// TriggerWords return the modelspec trigger phrase,
+// or the training tags ordered by frequency
+func (m SafetensorsMetadata) TriggerWords() []string {
+	if v := m["modelspec.trigger_phrase"]; v != "" {
+		var words []string
+		for _, w := range strings.Split(v, ",") {
+			if w = strings.TrimSpace(w); w != "" {
+				words = append(words, w)
+			}
+		}
+		return words
+	}
+
+	// {"dataset": {"tag": count}}
+	var freq map[string]map[string]int
+	if err := json.Unmarshal([]byte(m["ss_tag_frequency"]), &freq); err != nil {
+		return nil
+	}
+	count := make(map[string]int)
+	for _, tags := range freq {
+		for tag, n := range tags {
+			count[strings.TrimSpace(tag)] += n
+		}
+	}
+	words := make([]string, 0, len(count))
+	for tag := range count {
+		words = append(words, tag)
+	}
+	sort.Slice(words, func(i, j int) bool {
+		if count[words[i]] != count[words[j]] {
+			return count[words[i]] > count[words[j]]
+		}
+		return words[i] < words[j]
+	})
+	return words
+}
+
+// ViewMetadata retrieve the safetensors metadata of model filename in folder,
+// e.g.: ViewMetadata("loras", "add_detail.safetensors")
+func (c *Client) ViewMetadata(folder, filename string) (SafetensorsMetadata, error) {
+	return c.ViewMetadataContext(context.Background(), folder, filename)
+}
+
+func (c *Client) ViewMetadataContext(ctx context.Context, folder, filename string) (SafetensorsMetadata, error) {
+	params := url.Values{}
+	params.Add("filename", filename)
+	urlStr := c.reqURL(ReqPathViewMetadata) + "/" + url.PathEscape(folder)
+	run := c.getURL(ReqPathViewMetadata, urlStr, params)
+
+	var resp SafetensorsMetadata
+	if err := c.process(ctx, func(ctx context.Context) (*http.Response, error) {
+		resp, err := run(ctx)
+		if err == nil && resp.StatusCode == http.StatusNotFound {
+			_ = resp.Body.Close()
+			return nil, ErrMetadataNotFound
+		}
+		return resp, err
+	}, decodeJSON(&resp)); err != nil {
+		return nil, fmt.Errorf("process: %w", err)
+	}
+	return resp, nil
+}
+
+// IncompatibleLoras return the LoRA names in loras folder which are trained on
+// other base model, LoRA without metadata is skipped
+func (c *Client) IncompatibleLoras(ctx context.Context, base BaseModel, names ...string) ([]string, error) {
+	var incompatible []string
+	for _, name := range names {
+		meta, err := c.ViewMetadataContext(ctx, ModelFolderLoras, name)
+		if errors.Is(err, ErrMetadataNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("view metadata of %s: %w", name, err)
+		}
+		if !meta.BaseModel().Compatible(base) {
+			incompatible = append(incompatible, name)
+		}
+	}
+	return incompatible, nil
+}
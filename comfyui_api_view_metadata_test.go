@@ -0,0 +1,97 @@
+package comfyui
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSafetensorsMetadata(t *testing.T) {
+	tests := []struct {
+		name     string
+		meta     SafetensorsMetadata
+		base     BaseModel
+		triggers []string
+	}{
+		{
+			name: "kohya sd15",
+			meta: SafetensorsMetadata{
+				"ss_base_model_version": "sd_v1",
+				"ss_tag_frequency":      `{"10_alice":{"alice":10," blue dress":4},"5_bg":{"blue dress":3}}`,
+			},
+			base:     BaseModelSD15,
+			triggers: []string{"alice", "blue dress"},
+		},
+		{
+			name: "modelspec flux",
+			meta: SafetensorsMetadata{
+				"modelspec.architecture":   "flux-1-dev/lora",
+				"modelspec.trigger_phrase": "ohwx, portrait",
+			},
+			base:     BaseModelFlux,
+			triggers: []string{"ohwx", "portrait"},
+		},
+		{
+			name: "modelspec sdxl",
+			meta: SafetensorsMetadata{"modelspec.architecture": "stable-diffusion-xl-v1-base/lora"},
+			base: BaseModelSDXL,
+		},
+		{
+			name: "old kohya",
+			meta: SafetensorsMetadata{"ss_network_module": "networks.lora"},
+			base: BaseModelSD15,
+		},
+		{
+			name: "unknown",
+			meta: SafetensorsMetadata{"format": "pt"},
+			base: BaseModelUnknown,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.base, tt.meta.BaseModel())
+			assert.Equal(t, tt.triggers, tt.meta.TriggerWords())
+		})
+	}
+
+	assert.True(t, BaseModelUnknown.Compatible(BaseModelFlux))
+	assert.True(t, BaseModelFlux.Compatible(BaseModelFlux))
+	assert.False(t, BaseModelSD15.Compatible(BaseModelFlux))
+}
+
+func TestClient_ViewMetadata(t *testing.T) {
+	metas := map[string]SafetensorsMetadata{
+		"sd15.safetensors": {"ss_base_model_version": "sd_v1"},
+		"flux.safetensors": {"modelspec.architecture": "flux-1-dev/lora"},
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, string(ReqPathViewMetadata)+"/loras", r.URL.Path)
+		meta, ok := metas[r.URL.Query().Get("filename")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(meta)
+	}))
+	defer srv.Close()
+
+	c, err := New(Config{Endpoint: srv.URL})
+	require.NoError(t, err)
+
+	meta, err := c.ViewMetadata(ModelFolderLoras, "sd15.safetensors")
+	require.NoError(t, err)
+	assert.Equal(t, BaseModelSD15, meta.BaseModel())
+
+	_, err = c.ViewMetadata(ModelFolderLoras, "none.safetensors")
+	assert.ErrorIs(t, err, ErrMetadataNotFound)
+
+	got, err := c.IncompatibleLoras(context.Background(), BaseModelFlux,
+		"sd15.safetensors", "flux.safetensors", "none.safetensors")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"sd15.safetensors"}, got)
+}
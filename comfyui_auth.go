@@ -0,0 +1,95 @@
+package comfyui
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// RequestEditorFn mutate the request before sending, e.g.: add auth headers
+type RequestEditorFn func(req *http.Request) error
+
+type BasicAuth struct {
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+}
+
+type TLSConfig struct {
+	// CAFile is the PEM encoded CA to verify the server
+	CAFile string `mapstructure:"ca_file"`
+	// CertFile and KeyFile are the PEM encoded client certificate for mTLS
+	CertFile           string `mapstructure:"cert_file"`
+	KeyFile            string `mapstructure:"key_file"`
+	ServerName         string `mapstructure:"server_name"`
+	InsecureSkipVerify bool   `mapstructure:"insecure_skip_verify"`
+}
+
+// Build return nil if nothing is configured
+func (c TLSConfig) Build() (*tls.Config, error) {
+	if c == (TLSConfig{}) {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+	if c.CAFile != "" {
+		caPEM, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read ca file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no valid certificate in %s", c.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if c.CertFile != "" || c.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load key pair: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// editors return the request editors from config
+func (c Config) editors() []RequestEditorFn {
+	var editors []RequestEditorFn
+	for k, v := range c.Headers {
+		editors = append(editors, func(req *http.Request) error {
+			req.Header.Set(k, v)
+			return nil
+		})
+	}
+	if c.BearerToken != "" {
+		editors = append(editors, func(req *http.Request) error {
+			req.Header.Set("Authorization", "Bearer "+c.BearerToken)
+			return nil
+		})
+	}
+	if c.BasicAuth != nil {
+		auth := *c.BasicAuth
+		editors = append(editors, func(req *http.Request) error {
+			req.SetBasicAuth(auth.Username, auth.Password)
+			return nil
+		})
+	}
+	return editors
+}
+
+// wsHeader return the headers from request editors for WebSocket handshake
+func (c *Client) wsHeader() (http.Header, error) {
+	req, err := http.NewRequest(http.MethodGet, c.BaseURL.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("new request: %w", err)
+	}
+	if err := c.editRequest(req); err != nil {
+		return nil, err
+	}
+	return req.Header, nil
+}
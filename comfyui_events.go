@@ -0,0 +1,229 @@
+package comfyui
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/sko00o/comfyui-go/iface"
+	"github.com/sko00o/comfyui-go/ws"
+	"github.com/sko00o/comfyui-go/ws/message"
+)
+
+// Events connect with clientID and stream the decoded messages,
+// the channel is closed when ctx is done or the connection gives up.
+// The stream never waits for the consumer, the oldest events are dropped
+// if eventBufferSize events are not read, see message.Event.Dropped.
+func (c *Client) Events(ctx context.Context, clientID string) (<-chan message.Event, error) {
+	opts, err := c.WsOptions()
+	if err != nil {
+		return nil, fmt.Errorf("ws options: %w", err)
+	}
+	s := newEventStream(ctx, "")
+	upstream, err := ws.New(c.BaseURL, clientID, ws.HandlerFunc(func(messageType int, data []byte) {
+		if err := s.WriteMessage(messageType, data); err != nil {
+			c.log.With("client_id", clientID).Warnf("ws event: %v, skip", err)
+		}
+	}), c.log, append(opts, ws.WithContext(ctx))...)
+	if err != nil {
+		return nil, fmt.Errorf("new wsClient: %w", err)
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-upstream.Done():
+		}
+		// no message is delivered after closed
+		_ = upstream.Close()
+		_ = s.Close()
+	}()
+	return s.ch, nil
+}
+
+// Watch stream the decoded messages of promptID through the shared WsMux,
+// the prompt must be submitted with the client_id of WsMux.
+// The channel is closed after the final event of the prompt, see message.Event.IsFinal,
+// or when ctx is done or the connection gives up. The slow consumer drops events like Events,
+// the final event is always kept.
+func (c *Client) Watch(ctx context.Context, promptID string) (<-chan message.Event, error) {
+	mux, err := c.WsMux()
+	if err != nil {
+		return nil, fmt.Errorf("ws mux: %w", err)
+	}
+	s := newEventStream(ctx, promptID)
+	if _, err := mux.Process(promptID, s); err != nil {
+		return nil, fmt.Errorf("process: %w", err)
+	}
+	return s.ch, nil
+}
+
+var (
+	_ iface.MessageHandler   = (*eventStream)(nil)
+	_ iface.ConnStateHandler = (*eventStream)(nil)
+)
+
+// eventBufferSize is the events kept for a slow consumer
+const eventBufferSize = 64
+
+// eventStream decode the messages to ch
+type eventStream struct {
+	// promptID filter the events if it is not empty
+	promptID string
+	ch       chan message.Event
+
+	// running is the prompt executing now
+	running string
+
+	mu sync.Mutex
+	// dropped counts the events dropped
+	dropped   int
+	done      chan struct{}
+	closeOnce sync.Once
+	finish    sync.Once
+}
+
+func newEventStream(ctx context.Context, promptID string) *eventStream {
+	s := &eventStream{
+		promptID: promptID,
+		ch:       make(chan message.Event, eventBufferSize),
+		done:     make(chan struct{}),
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.stop()
+		case <-s.done:
+		}
+	}()
+	return s
+}
+
+func decodeEvent(messageType int, data []byte) (message.Event, error) {
+	ev := message.Event{Raw: data}
+	switch messageType {
+	case websocket.TextMessage:
+		var m message.Message
+		if err := json.Unmarshal(data, &m); err != nil {
+			return ev, err
+		}
+		ev.Type = m.Type
+		ev.Data = m.Data
+		if m.Data != nil {
+			ev.PromptID = m.Data.GetPromptID()
+		}
+	case websocket.BinaryMessage:
+		var b message.BinaryMessage
+		// unknown type is delivered with nil Data
+		if err := b.UnmarshalBinary(data); err != nil && !errors.Is(err, message.ErrUnknownBinaryType) {
+			return ev, fmt.Errorf("unmarshal binary: %w", err)
+		}
+		ev.Binary = &b
+	default:
+		return ev, fmt.Errorf("unsupported message type: %d", messageType)
+	}
+	return ev, nil
+}
+
+func (s *eventStream) WriteMessage(messageType int, data []byte) error {
+	ev, err := decodeEvent(messageType, data)
+	if err != nil {
+		return err
+	}
+	if ev.Binary != nil {
+		ev.PromptID = s.running
+		if o, ok := ev.Binary.Data.(*message.DataImageWithMetadata); ok && o.Metadata.PromptID != "" {
+			ev.PromptID = o.Metadata.PromptID
+		}
+	}
+	switch ev.Type {
+	case message.ExecutionStart:
+		s.running = ev.PromptID
+	case message.Executing:
+		if o, ok := ev.Data.(*message.DataExecuting); ok && o.Node != nil {
+			s.running = ev.PromptID
+		} else if s.running == ev.PromptID {
+			s.running = ""
+		}
+	}
+	if s.promptID != "" && ev.PromptID != s.promptID {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-s.done:
+		return nil
+	default:
+	}
+	s.send(ev)
+	if s.promptID != "" && ev.IsFinal() {
+		s.stopLocked()
+	}
+	return nil
+}
+
+// send ev without blocking, the oldest event is dropped if ch is full,
+// only WriteMessage sends to ch so there is room after dropping
+func (s *eventStream) send(ev message.Event) {
+	for {
+		ev.Dropped = s.dropped
+		select {
+		case s.ch <- ev:
+			return
+		default:
+		}
+		select {
+		case <-s.ch:
+			s.dropped++
+		default:
+			// read by the consumer
+		}
+	}
+}
+
+// ReadMessage block until the stream is stopped
+func (s *eventStream) ReadMessage() (int, []byte, error) {
+	<-s.done
+	return 0, nil, io.EOF
+}
+
+// Close the channel, it must be called after the last WriteMessage
+func (s *eventStream) Close() error {
+	s.stop()
+	s.closeOnce.Do(func() {
+		close(s.ch)
+	})
+	return nil
+}
+
+func (s *eventStream) Name() string {
+	return "events:" + s.promptID
+}
+
+func (s *eventStream) OnDisconnect(error) {}
+
+func (s *eventStream) OnReconnect() {}
+
+// OnGiveUp stop the stream, no more event will come
+func (s *eventStream) OnGiveUp(error) {
+	s.stop()
+}
+
+func (s *eventStream) stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stopLocked()
+}
+
+func (s *eventStream) stopLocked() {
+	s.finish.Do(func() {
+		close(s.done)
+	})
+}
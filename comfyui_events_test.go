@@ -0,0 +1,115 @@
+package comfyui
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sko00o/comfyui-go/ws/message"
+)
+
+func TestEventStream_SlowConsumer(t *testing.T) {
+	const total = 3 * eventBufferSize
+	// drain return the events until closed or all buffered are read
+	drain := func(ch <-chan message.Event) (events []message.Event) {
+		for {
+			select {
+			case ev, ok := <-ch:
+				if !ok {
+					return
+				}
+				events = append(events, ev)
+			default:
+				return
+			}
+		}
+	}
+
+	t.Run("events", func(t *testing.T) {
+		srv, send, _ := muxServer(t)
+		defer srv.Close()
+		defer close(send)
+		c, err := New(Config{Endpoint: srv.URL})
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		events, err := c.Events(ctx, "c1")
+		require.NoError(t, err)
+
+		// the stream is not read
+		for i := 0; i < total; i++ {
+			send <- muxMsg("executing", "p1", "3")
+		}
+		send <- muxMsg("executing", "p1", nil)
+		require.Eventually(t, func() bool {
+			return len(events) == eventBufferSize
+		}, time.Second, 10*time.Millisecond)
+		// wait for the final one
+		time.Sleep(200 * time.Millisecond)
+
+		got := drain(events)
+		require.Equal(t, eventBufferSize, len(got))
+		assert.True(t, got[len(got)-1].IsFinal(), "the newest is kept")
+		assert.Equal(t, total+1-eventBufferSize, got[len(got)-1].Dropped)
+
+		// still running
+		send <- muxMsg("executing", "p2", nil)
+		select {
+		case ev := <-events:
+			assert.Equal(t, "p2", ev.PromptID)
+			assert.Equal(t, total+1-eventBufferSize, ev.Dropped)
+		case <-time.After(time.Second):
+			t.Fatal("stream is stalled")
+		}
+	})
+
+	t.Run("watch", func(t *testing.T) {
+		srv, send, _ := muxServer(t)
+		defer srv.Close()
+		defer close(send)
+		c, err := New(Config{Endpoint: srv.URL})
+		require.NoError(t, err)
+		defer c.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		watched, err := c.Watch(ctx, "p1")
+		require.NoError(t, err)
+		m, err := c.WsMux()
+		require.NoError(t, err)
+		other := newFakeConsumer("p2")
+		_, err = m.Process("p2", other)
+		require.NoError(t, err)
+		defer close(other.done)
+
+		for i := 0; i < total; i++ {
+			send <- muxMsg("executing", "p1", "3")
+		}
+		send <- muxMsg("executing", "p1", nil)
+		send <- muxMsg("executing", "p2", nil)
+
+		// the other prompt on the shared connection is not held
+		require.Eventually(t, func() bool {
+			return len(other.Frames()) == 1
+		}, time.Second, 10*time.Millisecond)
+
+		// unregistered after the final event is queued
+		require.Eventually(t, func() bool {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			_, ok := m.handlers["p1"]
+			return !ok
+		}, time.Second, 10*time.Millisecond)
+		var got []message.Event
+		for ev := range watched {
+			got = append(got, ev)
+		}
+		require.Equal(t, eventBufferSize, len(got))
+		assert.True(t, got[len(got)-1].IsFinal())
+		assert.Equal(t, total+1-eventBufferSize, got[len(got)-1].Dropped)
+	})
+}
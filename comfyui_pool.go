@@ -0,0 +1,423 @@
+package comfyui
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"syscall"
+	"time"
+
+	comfyError "github.com/sko00o/comfyui-go/error"
+	"github.com/sko00o/comfyui-go/logger"
+	"github.com/sko00o/comfyui-go/ws/message"
+)
+
+var (
+	ErrNoHealthyEndpoint = errors.New("no healthy endpoint")
+	ErrUnknownPrompt     = errors.New("unknown prompt")
+)
+
+// Pool route prompts to several ComfyUI endpoints by their load,
+// and keep the endpoint of each prompt for the following requests
+type Pool struct {
+	members []*poolMember
+
+	mu       sync.Mutex
+	affinity map[string]affinity
+	// lastExpire is when the expired affinity was dropped
+	lastExpire time.Time
+
+	probeInterval time.Duration
+	probeTimeout  time.Duration
+	affinityTTL   time.Duration
+	minVRAMFree   int
+
+	log logger.LoggerExtend
+}
+
+type poolMember struct {
+	client *Client
+
+	// protected by Pool.mu
+	healthy        bool
+	lastProbe      time.Time
+	queueRemaining int
+	vramFree       int
+}
+
+// affinity is the endpoint of a prompt, it expires after Pool.affinityTTL since bound
+type affinity struct {
+	client  *Client
+	boundAt time.Time
+}
+
+// EndpointState is the last probed state of an endpoint
+type EndpointState struct {
+	Client         *Client
+	Healthy        bool
+	QueueRemaining int
+	VRAMFree       int
+	LastProbe      time.Time
+}
+
+type PoolOption func(p *Pool)
+
+func WithPoolLogger(l logger.LoggerExtend) PoolOption {
+	return func(p *Pool) {
+		p.log = l
+	}
+}
+
+// WithPoolProbeInterval set how long the probed load is trusted,
+// prompts submitted in between are counted locally
+func WithPoolProbeInterval(interval time.Duration) PoolOption {
+	return func(p *Pool) {
+		p.probeInterval = interval
+	}
+}
+
+// WithPoolProbeTimeout limit each probe of an endpoint, default is 3s,
+// a hung endpoint is taken out of rotation instead of stalling the picking
+func WithPoolProbeTimeout(timeout time.Duration) PoolOption {
+	return func(p *Pool) {
+		p.probeTimeout = timeout
+	}
+}
+
+// WithPoolAffinityTTL set how long the endpoint of a prompt is kept, default is 24h,
+// the expired prompts are searched on all endpoints by GetHistoryByID
+func WithPoolAffinityTTL(ttl time.Duration) PoolOption {
+	return func(p *Pool) {
+		p.affinityTTL = ttl
+	}
+}
+
+// WithPoolMinVRAMFree prefer endpoints with at least minBytes free VRAM,
+// others are only used if no endpoint meets it
+func WithPoolMinVRAMFree(minBytes int) PoolOption {
+	return func(p *Pool) {
+		p.minVRAMFree = minBytes
+	}
+}
+
+func NewPool(clients []*Client, opts ...PoolOption) *Pool {
+	p := &Pool{
+		affinity:      make(map[string]affinity),
+		probeInterval: time.Second * 5,
+		probeTimeout:  time.Second * 3,
+		affinityTTL:   time.Hour * 24,
+		log:           logger.NewStd(),
+	}
+	for _, c := range clients {
+		p.members = append(p.members, &poolMember{client: c, healthy: true})
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// NewPoolFromConfig create a client for each config and share opts among them
+func NewPoolFromConfig(cfgs []Config, opts []Option, poolOpts ...PoolOption) (*Pool, error) {
+	clients := make([]*Client, 0, len(cfgs))
+	for _, cfg := range cfgs {
+		c, err := New(cfg, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("new client %s: %w", cfg.Endpoint, err)
+		}
+		clients = append(clients, c)
+	}
+	return NewPool(clients, poolOpts...), nil
+}
+
+// Clients return all clients in the pool
+func (p *Pool) Clients() []*Client {
+	clients := make([]*Client, 0, len(p.members))
+	for _, m := range p.members {
+		clients = append(clients, m.client)
+	}
+	return clients
+}
+
+// States return the last probed state of each endpoint
+func (p *Pool) States() []EndpointState {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	states := make([]EndpointState, 0, len(p.members))
+	for _, m := range p.members {
+		states = append(states, EndpointState{
+			Client:         m.client,
+			Healthy:        m.healthy,
+			QueueRemaining: m.queueRemaining,
+			VRAMFree:       m.vramFree,
+			LastProbe:      m.lastProbe,
+		})
+	}
+	return states
+}
+
+// Refresh probe the queue and VRAM of all endpoints now
+func (p *Pool) Refresh(ctx context.Context) {
+	p.probe(ctx, p.members)
+}
+
+func (p *Pool) probe(ctx context.Context, members []*poolMember) {
+	var wg sync.WaitGroup
+	for _, m := range members {
+		wg.Add(1)
+		go func(m *poolMember) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, p.probeTimeout)
+			defer cancel()
+			queueRemaining, vramFree, err := probeMember(ctx, m.client)
+
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			m.lastProbe = time.Now()
+			if err != nil {
+				if m.healthy {
+					p.log.With("endpoint", m.client.BaseURL.String()).Warnf("take out of rotation: %v", err)
+				}
+				m.healthy = false
+				return
+			}
+			if !m.healthy {
+				p.log.With("endpoint", m.client.BaseURL.String()).Infof("back to rotation")
+			}
+			m.healthy = true
+			m.queueRemaining = queueRemaining
+			m.vramFree = vramFree
+		}(m)
+	}
+	wg.Wait()
+}
+
+func probeMember(ctx context.Context, c *Client) (queueRemaining, vramFree int, err error) {
+	prompt, err := c.GetPromptContext(ctx)
+	if err != nil {
+		return 0, 0, fmt.Errorf("get prompt: %w", err)
+	}
+	stats, err := c.StatsContext(ctx)
+	if err != nil {
+		return 0, 0, fmt.Errorf("stats: %w", err)
+	}
+	for _, device := range stats.Devices {
+		vramFree += device.VRAMFree
+	}
+	return prompt.ExecInfo.QueueRemaining, vramFree, nil
+}
+
+// Pick return the healthy client with the smallest queue,
+// the one with more free VRAM wins a tie
+func (p *Pool) Pick(ctx context.Context) (*Client, error) {
+	m, err := p.pick(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return m.client, nil
+}
+
+func (p *Pool) pick(ctx context.Context, exclude map[*poolMember]struct{}) (*poolMember, error) {
+	var stale []*poolMember
+	p.mu.Lock()
+	for _, m := range p.members {
+		if _, ok := exclude[m]; ok {
+			continue
+		}
+		if time.Since(m.lastProbe) >= p.probeInterval {
+			stale = append(stale, m)
+		}
+	}
+	p.mu.Unlock()
+	if len(stale) != 0 {
+		p.probe(ctx, stale)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var best, fallback *poolMember
+	for _, m := range p.members {
+		if _, ok := exclude[m]; ok || !m.healthy {
+			continue
+		}
+		if lessLoaded(m, fallback) {
+			fallback = m
+		}
+		if m.vramFree >= p.minVRAMFree && lessLoaded(m, best) {
+			best = m
+		}
+	}
+	if best == nil {
+		best = fallback
+	}
+	if best == nil {
+		return nil, ErrNoHealthyEndpoint
+	}
+	return best, nil
+}
+
+func lessLoaded(m, than *poolMember) bool {
+	if than == nil {
+		return true
+	}
+	if m.queueRemaining != than.queueRemaining {
+		return m.queueRemaining < than.queueRemaining
+	}
+	return m.vramFree > than.vramFree
+}
+
+// Prompt submit data to the least loaded endpoint,
+// another endpoint is tried only if the request never reached ComfyUI,
+// otherwise the prompt may have been queued and the error is returned
+func (p *Pool) Prompt(ctx context.Context, data map[string]any) (*QueuePromptResp, *Client, error) {
+	tried := make(map[*poolMember]struct{})
+	for {
+		m, err := p.pick(ctx, tried)
+		if err != nil {
+			return nil, nil, err
+		}
+		resp, err := m.client.PromptContext(ctx, data)
+		if err != nil {
+			if ctx.Err() != nil || !unreached(err) {
+				return nil, m.client, err
+			}
+			p.mu.Lock()
+			m.healthy = false
+			p.mu.Unlock()
+			p.log.With("endpoint", m.client.BaseURL.String()).Warnf("take out of rotation: %v", err)
+			tried[m] = struct{}{}
+			continue
+		}
+
+		p.mu.Lock()
+		m.queueRemaining++
+		p.bindLocked(resp.PromptID, m.client)
+		p.mu.Unlock()
+		return resp, m.client, nil
+	}
+}
+
+// unreached report whether the request failed before reaching ComfyUI,
+// i.e.: the connection is not established, or it is refused with 503 by a proxy
+func unreached(err error) bool {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" || errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+	var statusErr *StatusError
+	var comfyErr comfyError.ComfyUIError
+	return errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusServiceUnavailable ||
+		errors.As(err, &comfyErr) && comfyErr.StatusCode == http.StatusServiceUnavailable
+}
+
+// ClientOf return the client which promptID was submitted to
+func (p *Pool) ClientOf(promptID string) (*Client, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	a, ok := p.affinity[promptID]
+	if !ok || time.Since(a.boundAt) >= p.affinityTTL {
+		return nil, false
+	}
+	return a.client, true
+}
+
+// Bind record that promptID is on c, e.g. restored after restart
+func (p *Pool) Bind(promptID string, c *Client) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.bindLocked(promptID, c)
+}
+
+// bindLocked also drop the expired affinity, at most once per minute
+func (p *Pool) bindLocked(promptID string, c *Client) {
+	now := time.Now()
+	p.affinity[promptID] = affinity{client: c, boundAt: now}
+	if now.Sub(p.lastExpire) < time.Minute {
+		return
+	}
+	p.lastExpire = now
+	for id, a := range p.affinity {
+		if now.Sub(a.boundAt) >= p.affinityTTL {
+			delete(p.affinity, id)
+		}
+	}
+}
+
+// Forget drop the affinity of promptIDs when their outputs are no longer needed
+func (p *Pool) Forget(promptIDs ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, id := range promptIDs {
+		delete(p.affinity, id)
+	}
+}
+
+func (p *Pool) clientOf(promptID string) (*Client, error) {
+	c, ok := p.ClientOf(promptID)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownPrompt, promptID)
+	}
+	return c, nil
+}
+
+// GetView download the output file of promptID from its endpoint
+func (p *Pool) GetView(ctx context.Context, promptID string, f message.FileInfo, handle handleRespFunc) error {
+	c, err := p.clientOf(promptID)
+	if err != nil {
+		return err
+	}
+	return c.GetViewContext(ctx, f, handle)
+}
+
+// GetHistoryByID retrieve the history of promptID from its endpoint,
+// all endpoints are searched if the affinity is unknown,
+// ErrHistoryNotFound is returned only if no endpoint has it
+func (p *Pool) GetHistoryByID(ctx context.Context, promptID string) (*HistoryObj, error) {
+	if c, ok := p.ClientOf(promptID); ok {
+		return c.GetHistoryByIDContext(ctx, promptID)
+	}
+	var errs []error
+	for _, m := range p.members {
+		obj, err := m.client.GetHistoryByIDContext(ctx, promptID)
+		if err == nil {
+			p.Bind(promptID, m.client)
+			return obj, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		if !errors.Is(err, ErrHistoryNotFound) {
+			errs = append(errs, fmt.Errorf("%s: %w", m.client.BaseURL.String(), err))
+		}
+	}
+	if len(errs) != 0 {
+		return nil, errors.Join(errs...)
+	}
+	return nil, ErrHistoryNotFound
+}
+
+// Interrupt stop promptID on its endpoint
+func (p *Pool) Interrupt(ctx context.Context, promptID string) error {
+	c, err := p.clientOf(promptID)
+	if err != nil {
+		return err
+	}
+	return c.InterruptContext(ctx, promptID)
+}
+
+// DeleteFromQueue remove promptID from the pending queue of its endpoint
+func (p *Pool) DeleteFromQueue(ctx context.Context, promptID string) error {
+	c, err := p.clientOf(promptID)
+	if err != nil {
+		return err
+	}
+	if err := c.DeleteFromQueueContext(ctx, promptID); err != nil {
+		return err
+	}
+	// it will never run
+	p.Forget(promptID)
+	return nil
+}
@@ -0,0 +1,227 @@
+package comfyui
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeEndpoint struct {
+	*httptest.Server
+	queueRemaining atomic.Int32
+	vramFree       int
+	prompts        atomic.Int32
+	// promptDelay delays the response after the prompt is queued
+	promptDelay atomic.Int64
+	// promptStatus rejects the prompt before it is queued if not 0
+	promptStatus atomic.Int32
+	// probeDelay delays the queue state, e.g.: a hung endpoint
+	probeDelay atomic.Int64
+	// historyStatus fails the history if not 0
+	historyStatus atomic.Int32
+}
+
+func newFakeEndpoint(t *testing.T, name string, queueRemaining int32, vramFree int) *fakeEndpoint {
+	e := &fakeEndpoint{vramFree: vramFree}
+	e.queueRemaining.Store(queueRemaining)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/prompt", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Duration(e.probeDelay.Load())):
+		case <-r.Context().Done():
+			return
+		}
+		_ = json.NewEncoder(w).Encode(GetPromptResp{ExecInfo: ExecInfo{QueueRemaining: int(e.queueRemaining.Load())}})
+	})
+	mux.HandleFunc("POST /api/prompt", func(w http.ResponseWriter, r *http.Request) {
+		if status := e.promptStatus.Load(); status != 0 {
+			w.WriteHeader(int(status))
+			return
+		}
+		n := e.prompts.Add(1)
+		e.queueRemaining.Add(1)
+		time.Sleep(time.Duration(e.promptDelay.Load()))
+		_ = json.NewEncoder(w).Encode(QueuePromptResp{PromptID: name + "-" + strconv.Itoa(int(n))})
+	})
+	mux.HandleFunc("GET /api/system_stats", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(StatsResp{Devices: []DeviceInfo{{VRAMFree: e.vramFree}}})
+	})
+	mux.HandleFunc("GET /api/history/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if status := e.historyStatus.Load(); status != 0 {
+			w.WriteHeader(int(status))
+			return
+		}
+		id := r.PathValue("id")
+		if id[:len(name)] != name {
+			_, _ = w.Write([]byte(`{}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"` + id + `":{"outputs":{}}}`))
+	})
+	e.Server = httptest.NewServer(mux)
+	t.Cleanup(e.Close)
+	return e
+}
+
+func newTestPool(t *testing.T, endpoints []*fakeEndpoint, opts ...PoolOption) *Pool {
+	var cfgs []Config
+	for _, e := range endpoints {
+		cfgs = append(cfgs, Config{Endpoint: e.URL})
+	}
+	p, err := NewPoolFromConfig(cfgs, nil, opts...)
+	require.NoError(t, err)
+	return p
+}
+
+func TestPool_Prompt(t *testing.T) {
+	a := newFakeEndpoint(t, "a", 2, 1<<30)
+	b := newFakeEndpoint(t, "b", 1, 1<<30)
+	c := newFakeEndpoint(t, "c", 1, 8<<30)
+	p := newTestPool(t, []*fakeEndpoint{a, b, c}, WithPoolProbeInterval(time.Hour))
+	ctx := context.Background()
+
+	// c has the same queue as b but more free vram
+	resp, cli, err := p.Prompt(ctx, map[string]any{})
+	require.NoError(t, err)
+	assert.Equal(t, c.URL, cli.BaseURL.String())
+	assert.Equal(t, "c-1", resp.PromptID)
+
+	// local count makes b the least loaded before next probe
+	resp, cli, err = p.Prompt(ctx, map[string]any{})
+	require.NoError(t, err)
+	assert.Equal(t, b.URL, cli.BaseURL.String())
+
+	got, ok := p.ClientOf(resp.PromptID)
+	require.True(t, ok)
+	assert.Equal(t, b.URL, got.BaseURL.String())
+
+	// affinity is recovered by searching all endpoints
+	p.Forget(resp.PromptID)
+	_, err = p.GetHistoryByID(ctx, resp.PromptID)
+	require.NoError(t, err)
+	got, ok = p.ClientOf(resp.PromptID)
+	require.True(t, ok)
+	assert.Equal(t, b.URL, got.BaseURL.String())
+
+	assert.ErrorIs(t, p.Interrupt(ctx, "unknown"), ErrUnknownPrompt)
+}
+
+func TestPool_Unhealthy(t *testing.T) {
+	a := newFakeEndpoint(t, "a", 0, 1<<30)
+	b := newFakeEndpoint(t, "b", 5, 1<<30)
+	p := newTestPool(t, []*fakeEndpoint{a, b}, WithPoolProbeInterval(0))
+	ctx := context.Background()
+
+	a.Close()
+	_, cli, err := p.Prompt(ctx, map[string]any{})
+	require.NoError(t, err)
+	assert.Equal(t, b.URL, cli.BaseURL.String())
+	for _, s := range p.States() {
+		assert.Equal(t, s.Client.BaseURL.String() == b.URL, s.Healthy)
+	}
+
+	b.Close()
+	_, _, err = p.Prompt(ctx, map[string]any{})
+	assert.ErrorIs(t, err, ErrNoHealthyEndpoint)
+}
+
+func TestPool_NoFailover(t *testing.T) {
+	a := newFakeEndpoint(t, "a", 0, 1<<30)
+	b := newFakeEndpoint(t, "b", 5, 1<<30)
+	p, err := NewPoolFromConfig([]Config{
+		{Endpoint: a.URL, Timeout: 100 * time.Millisecond},
+		{Endpoint: b.URL, Timeout: 100 * time.Millisecond},
+	}, nil, WithPoolProbeInterval(time.Hour))
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	// a queued the prompt but the response is lost, submitting to b runs it twice
+	a.promptDelay.Store(int64(time.Second))
+	_, cli, err := p.Prompt(ctx, map[string]any{})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, a.URL, cli.BaseURL.String())
+	assert.Equal(t, int32(1), a.prompts.Load())
+	assert.Equal(t, int32(0), b.prompts.Load())
+
+	// a rejected the prompt before queueing it
+	a.promptDelay.Store(0)
+	a.promptStatus.Store(http.StatusServiceUnavailable)
+	_, cli, err = p.Prompt(ctx, map[string]any{})
+	require.NoError(t, err)
+	assert.Equal(t, b.URL, cli.BaseURL.String())
+	assert.Equal(t, int32(1), b.prompts.Load())
+}
+
+func TestPool_MinVRAMFree(t *testing.T) {
+	a := newFakeEndpoint(t, "a", 0, 1<<30)
+	b := newFakeEndpoint(t, "b", 3, 8<<30)
+	p := newTestPool(t, []*fakeEndpoint{a, b}, WithPoolMinVRAMFree(4<<30))
+
+	cli, err := p.Pick(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, b.URL, cli.BaseURL.String())
+}
+
+func TestPool_ProbeTimeout(t *testing.T) {
+	a := newFakeEndpoint(t, "a", 0, 1<<30)
+	b := newFakeEndpoint(t, "b", 5, 1<<30)
+	a.probeDelay.Store(int64(time.Minute))
+	p := newTestPool(t, []*fakeEndpoint{a, b}, WithPoolProbeTimeout(100*time.Millisecond))
+
+	// the hung endpoint is out of rotation without stalling the picking
+	start := time.Now()
+	cli, err := p.Pick(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, b.URL, cli.BaseURL.String())
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestPool_AffinityTTL(t *testing.T) {
+	a := newFakeEndpoint(t, "a", 0, 1<<30)
+	p := newTestPool(t, []*fakeEndpoint{a}, WithPoolAffinityTTL(50*time.Millisecond))
+	ctx := context.Background()
+
+	resp, _, err := p.Prompt(ctx, map[string]any{})
+	require.NoError(t, err)
+	_, ok := p.ClientOf(resp.PromptID)
+	assert.True(t, ok)
+
+	time.Sleep(60 * time.Millisecond)
+	_, ok = p.ClientOf(resp.PromptID)
+	assert.False(t, ok)
+
+	// the expired ones are dropped by the next binding
+	p.lastExpire = time.Time{}
+	p.Bind("a-x", p.members[0].client)
+	assert.Len(t, p.affinity, 1)
+}
+
+func TestPool_GetHistoryByID(t *testing.T) {
+	a := newFakeEndpoint(t, "a", 0, 1<<30)
+	b := newFakeEndpoint(t, "b", 0, 1<<30)
+	p := newTestPool(t, []*fakeEndpoint{a, b})
+
+	_, err := p.GetHistoryByID(context.Background(), "c-1")
+	assert.ErrorIs(t, err, ErrHistoryNotFound)
+
+	// b may have it but failed
+	b.historyStatus.Store(http.StatusForbidden)
+	_, err = p.GetHistoryByID(context.Background(), "c-1")
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrHistoryNotFound)
+	var statusErr *StatusError
+	assert.ErrorAs(t, err, &statusErr)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = p.GetHistoryByID(ctx, "c-1")
+	assert.ErrorIs(t, err, context.Canceled)
+}
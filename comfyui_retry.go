@@ -0,0 +1,111 @@
+package comfyui
+
+import (
+	"context"
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// RetryConfig is the retry policy of idempotent requests,
+// the default timeout of Config covers all attempts of a request
+type RetryConfig struct {
+	// MaxAttempts includes the first attempt, retry is disabled if less than 2
+	MaxAttempts int `mapstructure:"max_attempts"`
+	// InitialBackoff is the delay before the first retry, default is 500ms
+	InitialBackoff time.Duration `mapstructure:"initial_backoff"`
+	// MaxBackoff is the max delay between attempts, default is 10s
+	MaxBackoff time.Duration `mapstructure:"max_backoff"`
+	// Multiplier grows the delay for each retry, default is 2
+	Multiplier float64 `mapstructure:"multiplier"`
+	// Jitter randomizes the delay by the ratio in [0, 1], default is 0.2
+	Jitter float64 `mapstructure:"jitter"`
+	// RetryableStatus default is 429, 502, 503 and 504
+	RetryableStatus []int `mapstructure:"retryable_status"`
+
+	// RetryPrompt enable retry for submitting prompt,
+	// it may queue the same prompt twice if the response is lost
+	RetryPrompt bool `mapstructure:"retry_prompt"`
+}
+
+var defaultRetryableStatus = []int{
+	http.StatusTooManyRequests,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+func (p RetryConfig) withDefault() RetryConfig {
+	if p.InitialBackoff <= 0 {
+		p.InitialBackoff = 500 * time.Millisecond
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = 10 * time.Second
+	}
+	if p.Multiplier < 1 {
+		p.Multiplier = 2
+	}
+	if p.Jitter <= 0 || p.Jitter > 1 {
+		p.Jitter = 0.2
+	}
+	if len(p.RetryableStatus) == 0 {
+		p.RetryableStatus = defaultRetryableStatus
+	}
+	return p
+}
+
+// backoff return the delay after the attempt-th attempt
+func (p RetryConfig) backoff(attempt int) time.Duration {
+	d := float64(p.InitialBackoff) * math.Pow(p.Multiplier, float64(attempt-1))
+	d = math.Min(d, float64(p.MaxBackoff))
+	d += d * p.Jitter * (rand.Float64()*2 - 1)
+	return time.Duration(d)
+}
+
+func (p RetryConfig) shouldRetry(ctx context.Context, resp *http.Response, err error) bool {
+	if err != nil {
+		// no retry if canceled or timeout by caller
+		return ctx.Err() == nil
+	}
+	return slices.Contains(p.RetryableStatus, resp.StatusCode)
+}
+
+func WithRetry(p RetryConfig) Option {
+	return func(c *Client) {
+		c.retry = p.withDefault()
+	}
+}
+
+// withRetry wrap run with the retry policy, run must be replayable
+func (c *Client) withRetry(path ReqPath, run getRespFunc) getRespFunc {
+	p := c.retry
+	if p.MaxAttempts < 2 {
+		return run
+	}
+	return func(ctx context.Context) (*http.Response, error) {
+		for attempt := 1; ; attempt++ {
+			resp, err := run(ctx)
+			if attempt >= p.MaxAttempts || !p.shouldRetry(ctx, resp, err) {
+				return resp, err
+			}
+
+			reason := any(err)
+			if resp != nil {
+				reason = resp.Status
+				_, _ = io.Copy(io.Discard, resp.Body)
+				_ = resp.Body.Close()
+			}
+			delay := p.backoff(attempt)
+			c.log.With("path", path, "attempt", attempt).Warnf("request failed: %v, retry in %s", reason, delay)
+
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(delay):
+			}
+		}
+	}
+}
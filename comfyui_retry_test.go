@@ -0,0 +1,93 @@
+package comfyui
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_Retry(t *testing.T) {
+	var failures, calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if failures.Load() > 0 {
+			failures.Add(-1)
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			_, _ = w.Write([]byte(`{"exec_info":{"queue_remaining":0}}`))
+		case http.MethodPost:
+			_, _ = w.Write([]byte(`{"prompt_id":"p1","number":1}`))
+		}
+	}))
+	defer srv.Close()
+
+	retry := RetryConfig{
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     5 * time.Millisecond,
+	}
+	c, err := New(Config{Endpoint: srv.URL, Retry: retry})
+	require.NoError(t, err)
+
+	// GET is retried
+	failures.Store(2)
+	calls.Store(0)
+	_, err = c.GetPrompt()
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), calls.Load())
+
+	// give up after max attempts
+	failures.Store(3)
+	calls.Store(0)
+	_, err = c.GetPrompt()
+	assert.Error(t, err)
+	assert.Equal(t, int32(3), calls.Load())
+
+	// prompt is not retried by default
+	failures.Store(1)
+	calls.Store(0)
+	_, err = c.Prompt(map[string]any{})
+	assert.Error(t, err)
+	assert.Equal(t, int32(1), calls.Load())
+
+	retry.RetryPrompt = true
+	c, err = New(Config{Endpoint: srv.URL}, WithRetry(retry))
+	require.NoError(t, err)
+	failures.Store(1)
+	calls.Store(0)
+	resp, err := c.Prompt(map[string]any{})
+	require.NoError(t, err)
+	assert.Equal(t, "p1", resp.PromptID)
+	assert.Equal(t, int32(2), calls.Load())
+
+	// reboot is never retried
+	failures.Store(1)
+	calls.Store(0)
+	assert.Error(t, c.Reboot())
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func TestRetryConfig_Backoff(t *testing.T) {
+	p := RetryConfig{
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     time.Second,
+	}.withDefault()
+
+	for attempt, want := range map[int]time.Duration{
+		1:  100 * time.Millisecond,
+		2:  200 * time.Millisecond,
+		3:  400 * time.Millisecond,
+		10: time.Second,
+	} {
+		got := p.backoff(attempt)
+		assert.InDelta(t, float64(want), float64(got), float64(want)*p.Jitter)
+	}
+}
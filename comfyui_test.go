@@ -0,0 +1,130 @@
+package comfyui
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sko00o/comfyui-go/iface"
+)
+
+func TestClient_ContextDeadline(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(200 * time.Millisecond):
+		case <-r.Context().Done():
+			return
+		}
+		_, _ = w.Write([]byte(`{"exec_info":{"queue_remaining":1}}`))
+	}))
+	defer srv.Close()
+
+	c, err := New(Config{Endpoint: srv.URL, Timeout: 50 * time.Millisecond})
+	require.NoError(t, err)
+
+	// default timeout applies
+	_, err = c.GetPrompt()
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// the requests sent by the embedded http.Client also have the default timeout
+	_, err = c.Get(srv.URL)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// per-call deadline overrides the default timeout
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	resp, err := c.GetPromptContext(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, resp.ExecInfo.QueueRemaining)
+
+	// canceled context aborts the request
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	_, err = c.GetPromptContext(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestClient_RequestEditors(t *testing.T) {
+	var gotHeader http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotHeader = r.Header.Clone()
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	c, err := New(Config{
+		Endpoint:    srv.URL,
+		Headers:     map[string]string{"X-Cluster": "a"},
+		BearerToken: "token",
+	}, WithHeader("X-Extra", "b"))
+	require.NoError(t, err)
+
+	_, err = c.GetPrompt()
+	require.NoError(t, err)
+	assert.Equal(t, "a", gotHeader.Get("X-Cluster"))
+	assert.Equal(t, "b", gotHeader.Get("X-Extra"))
+	assert.Equal(t, "Bearer token", gotHeader.Get("Authorization"))
+
+	// ws handshake shares the same headers
+	header, err := c.wsHeader()
+	require.NoError(t, err)
+	assert.Equal(t, "Bearer token", header.Get("Authorization"))
+	assert.Equal(t, "b", header.Get("X-Extra"))
+
+	c, err = New(Config{
+		Endpoint:  srv.URL,
+		BasicAuth: &BasicAuth{Username: "user", Password: "pass"},
+	})
+	require.NoError(t, err)
+	_, err = c.GetPrompt()
+	require.NoError(t, err)
+	user, pass, ok := (&http.Request{Header: gotHeader}).BasicAuth()
+	assert.True(t, ok)
+	assert.Equal(t, "user", user)
+	assert.Equal(t, "pass", pass)
+
+	_, err = New(Config{Endpoint: srv.URL, TLS: TLSConfig{CAFile: "not-exists.pem"}})
+	assert.Error(t, err)
+}
+
+type requestMetrics struct {
+	iface.NopMetrics
+	requests []string
+}
+
+func (m *requestMetrics) StartRequest(ctx context.Context, path, method string) (context.Context, func(int, error)) {
+	return ctx, func(statusCode int, err error) {
+		m.requests = append(m.requests, fmt.Sprintf("%s %s %d", method, path, statusCode))
+	}
+}
+
+func TestClient_Metrics(t *testing.T) {
+	// the path prefix of endpoint is not in the labels
+	srv := httptest.NewServer(http.StripPrefix("/comfy", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/view_metadata/loras" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(`{}`))
+	})))
+	defer srv.Close()
+
+	m := &requestMetrics{}
+	c, err := New(Config{Endpoint: srv.URL + "/comfy"}, WithMetrics(m))
+	require.NoError(t, err)
+
+	_, _ = c.GetHistoryByID("p1")
+	_, _ = c.ViewMetadata(ModelFolderLoras, "a.safetensors")
+	_, _ = c.Stats()
+	assert.Equal(t, []string{
+		"GET /api/history 200",
+		"GET /api/view_metadata 404",
+		"GET /api/system_stats 200",
+	}, m.requests)
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net/http"
 	"net/url"
 	"sync"
 
@@ -18,11 +19,18 @@ type SimpleWsClient struct {
 	log      logger.Logger
 }
 
-func NewSimpleWsClient(BaseURL url.URL, clientID string, consumer iface.MessageHandler, log logger.LoggerExtend) (*SimpleWsClient, error) {
+func NewSimpleWsClient(BaseURL url.URL, clientID string, consumer iface.MessageHandler, log logger.LoggerExtend, opts ...ws.Option) (*SimpleWsClient, error) {
 	client := &SimpleWsClient{
 		log:  log,
 		conn: consumer,
 	}
+	if h, ok := consumer.(iface.ConnStateHandler); ok {
+		opts = append(opts[:len(opts):len(opts)],
+			ws.WithOnDisconnect(h.OnDisconnect),
+			ws.WithOnReconnect(func(int) { h.OnReconnect() }),
+			ws.WithOnGiveUp(h.OnGiveUp),
+		)
+	}
 	upstream, err := ws.New(
 		BaseURL,
 		clientID,
@@ -32,6 +40,7 @@ func NewSimpleWsClient(BaseURL url.URL, clientID string, consumer iface.MessageH
 			}
 		}),
 		log,
+		opts...,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("create upstream client failed: %w", err)
@@ -48,8 +57,39 @@ func (c *SimpleWsClient) Close() error {
 	return c.upstream.Close()
 }
 
+// WsOptions return the ws options sharing the headers and TLS config of c
+func (c *Client) WsOptions() ([]ws.Option, error) {
+	header, err := c.wsHeader()
+	if err != nil {
+		return nil, fmt.Errorf("ws header: %w", err)
+	}
+	opts := []ws.Option{
+		ws.WithHeader(header),
+		ws.WithMetrics(c.metrics),
+		ws.WithReconnect(c.wsReconnect),
+		ws.WithKeepalive(c.wsKeepalive),
+	}
+
+	tlsConfig := c.tlsConfig
+	rt := c.Transport
+	if t, ok := rt.(*timeoutTransport); ok {
+		rt = t.next
+	}
+	if t, ok := rt.(*http.Transport); ok && t.TLSClientConfig != nil {
+		tlsConfig = t.TLSClientConfig
+	}
+	if tlsConfig != nil {
+		opts = append(opts, ws.WithTLSConfig(tlsConfig))
+	}
+	return append(opts, c.wsOpts...), nil
+}
+
 func (c *Client) SimpleProcess(id string, consumer iface.MessageHandler) (*sync.WaitGroup, error) {
-	client, err := NewSimpleWsClient(c.BaseURL, id, consumer, c.log)
+	opts, err := c.WsOptions()
+	if err != nil {
+		return nil, fmt.Errorf("ws options: %w", err)
+	}
+	client, err := NewSimpleWsClient(c.BaseURL, id, consumer, c.log, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("new wsClient: %w", err)
 	}
@@ -0,0 +1,356 @@
+package comfyui
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/sko00o/comfyui-go/helper"
+	"github.com/sko00o/comfyui-go/iface"
+	"github.com/sko00o/comfyui-go/logger"
+	"github.com/sko00o/comfyui-go/ws"
+	"github.com/sko00o/comfyui-go/ws/message"
+)
+
+var (
+	ErrWsMuxClosed = errors.New("ws mux closed")
+	// ErrWsMuxOverflow is reported by OnDisconnect when the frames of a slow handler are dropped
+	ErrWsMuxOverflow = errors.New("ws mux queue overflow")
+)
+
+const (
+	// muxBacklogTTL is how long the messages of an unregistered prompt are kept
+	muxBacklogTTL = time.Minute
+	// muxBacklogSize is the max messages kept for an unregistered prompt
+	muxBacklogSize = 1024
+	// muxQueueSize is the max messages queued for a handler, the backlog must fit in it
+	muxQueueSize = muxBacklogSize
+)
+
+// WsMux share one WebSocket connection among the prompts submitted with its ClientID,
+// text messages are dispatched by prompt_id, binary messages go to the executing prompt.
+// Each handler is called by its own goroutine, so a slow one does not hold the others.
+type WsMux struct {
+	upstream *ws.Client
+	clientID string
+
+	mu       sync.Mutex
+	handlers map[string]*muxHandler
+	// backlog keeps the messages arrived before the prompt is registered
+	backlog map[string]*muxBacklog
+	// running is the prompt executing now
+	running string
+
+	log logger.LoggerExtend
+}
+
+type muxFrame struct {
+	messageType int
+	data        []byte
+}
+
+type muxBacklog struct {
+	since  time.Time
+	frames []muxFrame
+}
+
+// muxHandler deliver the frames queued to h in order, the frames are dropped if the queue is full
+// and h is told by OnDisconnect if it is a ConnStateHandler, e.g.: Session recovers from history
+type muxHandler struct {
+	h       iface.MessageHandler
+	queue   chan muxFrame
+	dropped atomic.Int64
+	stop    chan struct{}
+	done    chan struct{}
+	log     logger.Logger
+}
+
+func newMuxHandler(h iface.MessageHandler, log logger.Logger) *muxHandler {
+	mh := &muxHandler{
+		h:     h,
+		queue: make(chan muxFrame, muxQueueSize),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+		log:   log,
+	}
+	go mh.run()
+	return mh
+}
+
+// push never blocks
+func (mh *muxHandler) push(f muxFrame) {
+	select {
+	case mh.queue <- f:
+	default:
+		if mh.dropped.Add(1) == 1 {
+			mh.log.Warnf("ws handler %s is slow, drop messages", mh.h.Name())
+		}
+	}
+}
+
+func (mh *muxHandler) run() {
+	defer close(mh.done)
+	for {
+		if n := mh.dropped.Swap(0); n > 0 {
+			if h, ok := mh.h.(iface.ConnStateHandler); ok {
+				h.OnDisconnect(fmt.Errorf("%w: %d messages dropped", ErrWsMuxOverflow, n))
+			}
+		}
+		select {
+		case <-mh.stop:
+			return
+		case f := <-mh.queue:
+			if err := mh.h.WriteMessage(f.messageType, f.data); err != nil {
+				mh.log.Errorf("write message to %s failed: %v", mh.h.Name(), err)
+			}
+		}
+	}
+}
+
+// close stop the delivery, the frames queued are dropped
+func (mh *muxHandler) close() {
+	close(mh.stop)
+	<-mh.done
+}
+
+// NewWsMux connect to c with a new client ID, opts are applied after c.WsOptions
+func NewWsMux(c *Client, opts ...ws.Option) (*WsMux, error) {
+	wsOpts, err := c.WsOptions()
+	if err != nil {
+		return nil, fmt.Errorf("ws options: %w", err)
+	}
+	m := &WsMux{
+		clientID: helper.NewID(),
+		handlers: make(map[string]*muxHandler),
+		backlog:  make(map[string]*muxBacklog),
+	}
+	m.log = c.log.With("client_id", m.clientID)
+	wsOpts = append(wsOpts,
+		ws.WithOnDisconnect(func(err error) {
+			for _, h := range m.connStateHandlers() {
+				h.OnDisconnect(err)
+			}
+		}),
+		ws.WithOnReconnect(func(int) {
+			for _, h := range m.connStateHandlers() {
+				h.OnReconnect()
+			}
+		}),
+		ws.WithOnGiveUp(func(err error) {
+			for _, h := range m.connStateHandlers() {
+				h.OnGiveUp(err)
+			}
+		}),
+	)
+	upstream, err := ws.New(c.BaseURL, m.clientID, ws.HandlerFunc(m.dispatch), c.log, append(wsOpts, opts...)...)
+	if err != nil {
+		return nil, fmt.Errorf("create upstream client failed: %w", err)
+	}
+	m.upstream = upstream
+	return m, nil
+}
+
+// WsMux return the multiplexer shared by all callers of c,
+// a new one is connected if the last one has given up
+func (c *Client) WsMux() (*WsMux, error) {
+	c.muxMu.Lock()
+	defer c.muxMu.Unlock()
+	if c.mux != nil {
+		select {
+		case <-c.mux.Done():
+		default:
+			return c.mux, nil
+		}
+	}
+	m, err := NewWsMux(c)
+	if err != nil {
+		return nil, err
+	}
+	c.mux = m
+	return m, nil
+}
+
+// ClientID must be the client_id of the prompts, so ComfyUI sends their messages to this connection
+func (m *WsMux) ClientID() string {
+	return m.clientID
+}
+
+// Done is closed when the connection is closed or gives up reconnecting
+func (m *WsMux) Done() <-chan struct{} {
+	return m.upstream.Done()
+}
+
+// Register deliver the messages of promptID to h, including the ones arrived before
+func (m *WsMux) Register(promptID string, h iface.MessageHandler) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	select {
+	case <-m.Done():
+		return fmt.Errorf("%w: %w", ErrWsMuxClosed, m.upstream.Err())
+	default:
+	}
+	if _, ok := m.handlers[promptID]; ok {
+		return fmt.Errorf("prompt %s is already registered", promptID)
+	}
+	mh := newMuxHandler(h, m.log.With("prompt_id", promptID))
+	m.handlers[promptID] = mh
+	if b, ok := m.backlog[promptID]; ok {
+		delete(m.backlog, promptID)
+		for _, f := range b.frames {
+			mh.push(f)
+		}
+	}
+	return nil
+}
+
+// Unregister stop delivering the messages of promptID,
+// it waits for the message being handled
+func (m *WsMux) Unregister(promptID string) {
+	m.mu.Lock()
+	mh, ok := m.handlers[promptID]
+	delete(m.handlers, promptID)
+	m.mu.Unlock()
+	if ok {
+		mh.close()
+	}
+}
+
+// Process is like Client.SimpleProcess on the shared connection,
+// consumer is unregistered and closed when its ReadMessage returns
+func (m *WsMux) Process(promptID string, consumer iface.MessageHandler) (*sync.WaitGroup, error) {
+	if err := m.Register(promptID, consumer); err != nil {
+		return nil, fmt.Errorf("register: %w", err)
+	}
+
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer func() {
+			m.Unregister(promptID)
+			if err := consumer.Close(); err != nil {
+				m.log.Errorf("close consumer failed: %v", err)
+			}
+		}()
+		for {
+			_, _, err := consumer.ReadMessage()
+			if err != nil {
+				if !errors.Is(err, io.EOF) {
+					m.log.With("prompt_id", promptID).Warnf("ws consumer %s read: %v", consumer.Name(), err)
+				}
+				break
+			}
+		}
+	}()
+	return wg, nil
+}
+
+// Close the connection, the registered handlers are not closed
+func (m *WsMux) Close() error {
+	return m.upstream.Close()
+}
+
+// connStateHandlers is copied under lock, the callbacks are called without it
+func (m *WsMux) connStateHandlers() []iface.ConnStateHandler {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var hs []iface.ConnStateHandler
+	for _, mh := range m.handlers {
+		if h, ok := mh.h.(iface.ConnStateHandler); ok {
+			hs = append(hs, h)
+		}
+	}
+	return hs
+}
+
+type muxMessage struct {
+	Type message.Type `json:"type"`
+	Data struct {
+		PromptID string  `json:"prompt_id"`
+		Node     *string `json:"node"`
+	} `json:"data"`
+}
+
+func (m *WsMux) dispatch(messageType int, data []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if messageType == websocket.BinaryMessage {
+		promptID := binaryPromptID(data)
+		if promptID == "" {
+			promptID = m.running
+		}
+		if promptID == "" {
+			return
+		}
+		m.deliverTo(promptID, messageType, data)
+		return
+	}
+
+	var msg muxMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		m.log.Warnf("TXT message unmarshal: %v, skip", err)
+		return
+	}
+	promptID := msg.Data.PromptID
+	if promptID == "" {
+		// e.g.: status, broadcast to all prompts
+		for _, mh := range m.handlers {
+			mh.push(muxFrame{messageType, data})
+		}
+		return
+	}
+
+	switch msg.Type {
+	case message.ExecutionStart:
+		m.running = promptID
+	case message.Executing:
+		if msg.Data.Node != nil {
+			m.running = promptID
+		} else if m.running == promptID {
+			m.running = ""
+		}
+	}
+	m.deliverTo(promptID, messageType, data)
+}
+
+// deliverTo deliver to the handler of promptID or keep it in backlog
+func (m *WsMux) deliverTo(promptID string, messageType int, data []byte) {
+	if mh, ok := m.handlers[promptID]; ok {
+		mh.push(muxFrame{messageType, data})
+		return
+	}
+
+	now := time.Now()
+	for id, b := range m.backlog {
+		if now.Sub(b.since) > muxBacklogTTL {
+			delete(m.backlog, id)
+		}
+	}
+	b, ok := m.backlog[promptID]
+	if !ok {
+		b = &muxBacklog{since: now}
+		m.backlog[promptID] = b
+	}
+	if len(b.frames) < muxBacklogSize {
+		b.frames = append(b.frames, muxFrame{messageType, data})
+	}
+}
+
+// binaryPromptID return the prompt_id in preview metadata, empty if not known
+func binaryPromptID(data []byte) string {
+	var b message.BinaryMessage
+	if err := b.UnmarshalBinary(data); err != nil {
+		return ""
+	}
+	if o, ok := b.Data.(*message.DataImageWithMetadata); ok {
+		return o.Metadata.PromptID
+	}
+	return ""
+}
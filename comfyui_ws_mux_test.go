@@ -0,0 +1,266 @@
+package comfyui
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeConsumer struct {
+	name string
+
+	mu     sync.Mutex
+	frames []string
+	done   chan struct{}
+	closed atomic.Bool
+}
+
+func newFakeConsumer(name string) *fakeConsumer {
+	return &fakeConsumer{name: name, done: make(chan struct{})}
+}
+
+func (c *fakeConsumer) WriteMessage(messageType int, data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if messageType == websocket.BinaryMessage {
+		c.frames = append(c.frames, "bin:"+string(data))
+		return nil
+	}
+	var m struct {
+		Type string `json:"type"`
+	}
+	_ = json.Unmarshal(data, &m)
+	c.frames = append(c.frames, m.Type)
+	return nil
+}
+
+func (c *fakeConsumer) ReadMessage() (int, []byte, error) {
+	<-c.done
+	return 0, nil, io.EOF
+}
+
+func (c *fakeConsumer) Close() error {
+	c.closed.Store(true)
+	return nil
+}
+
+func (c *fakeConsumer) Name() string {
+	return c.name
+}
+
+func (c *fakeConsumer) Frames() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.frames...)
+}
+
+// blockingConsumer block in WriteMessage until unblock is closed
+type blockingConsumer struct {
+	*fakeConsumer
+	unblock      chan struct{}
+	disconnected chan error
+}
+
+func (c *blockingConsumer) WriteMessage(messageType int, data []byte) error {
+	<-c.unblock
+	return c.fakeConsumer.WriteMessage(messageType, data)
+}
+
+func (c *blockingConsumer) OnDisconnect(err error) {
+	c.disconnected <- err
+}
+
+func (c *blockingConsumer) OnReconnect()     {}
+func (c *blockingConsumer) OnGiveUp(_ error) {}
+
+// muxServer send the frames of send to every connection
+func muxServer(t *testing.T) (*httptest.Server, chan<- []byte, *atomic.Int32) {
+	t.Helper()
+	var conns atomic.Int32
+	send := make(chan []byte, 2*muxQueueSize)
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conns.Add(1)
+		// answer the close frame
+		go func() {
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					_ = conn.Close()
+					return
+				}
+			}
+		}()
+		for p := range send {
+			messageType := websocket.TextMessage
+			if p[0] != '{' {
+				messageType = websocket.BinaryMessage
+			}
+			if err := conn.WriteMessage(messageType, p); err != nil {
+				return
+			}
+		}
+	}))
+	return srv, send, &conns
+}
+
+func muxMsg(typ, promptID string, node any) []byte {
+	p, _ := json.Marshal(map[string]any{
+		"type": typ,
+		"data": map[string]any{"prompt_id": promptID, "node": node},
+	})
+	return p
+}
+
+func TestWsMux(t *testing.T) {
+	srv, send, conns := muxServer(t)
+	defer srv.Close()
+	defer close(send)
+
+	c, err := New(Config{Endpoint: srv.URL})
+	require.NoError(t, err)
+	m, err := c.WsMux()
+	require.NoError(t, err)
+	defer m.Close()
+	same, err := c.WsMux()
+	require.NoError(t, err)
+	assert.Same(t, m, same)
+	assert.NotEmpty(t, m.ClientID())
+
+	p1, p2 := newFakeConsumer("p1"), newFakeConsumer("p2")
+	wg1, err := m.Process("p1", p1)
+	require.NoError(t, err)
+	assert.Error(t, m.Register("p1", p1), "registered twice")
+
+	msg := muxMsg
+	for _, p := range [][]byte{
+		[]byte(`{"type":"status","data":{"status":{"exec_info":{"queue_remaining":2}}}}`),
+		msg("execution_start", "p1", nil),
+		msg("executing", "p1", "3"),
+		[]byte("preview-1"),
+		msg("executing", "p1", nil),
+		// p2 is registered later
+		msg("execution_start", "p2", nil),
+		msg("executing", "p2", "3"),
+		[]byte("preview-2"),
+		msg("executing", "p2", nil),
+	} {
+		send <- p
+	}
+
+	require.Eventually(t, func() bool {
+		return len(p1.Frames()) == 5
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"status", "execution_start", "executing", "bin:preview-1", "executing"}, p1.Frames())
+
+	// wait until the messages of p2 are in backlog
+	require.Eventually(t, func() bool {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		return m.backlog["p2"] != nil && len(m.backlog["p2"].frames) == 4
+	}, time.Second, 10*time.Millisecond)
+	require.NoError(t, m.Register("p2", p2))
+	require.Eventually(t, func() bool {
+		return len(p2.Frames()) == 4
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"execution_start", "executing", "bin:preview-2", "executing"}, p2.Frames())
+
+	close(p1.done)
+	wg1.Wait()
+	assert.True(t, p1.closed.Load())
+	m.mu.Lock()
+	_, ok := m.handlers["p1"]
+	m.mu.Unlock()
+	assert.False(t, ok)
+	assert.Equal(t, int32(1), conns.Load())
+}
+
+func TestWsMux_SlowHandler(t *testing.T) {
+	srv, send, _ := muxServer(t)
+	defer srv.Close()
+	defer close(send)
+
+	c, err := New(Config{Endpoint: srv.URL})
+	require.NoError(t, err)
+	m, err := c.WsMux()
+	require.NoError(t, err)
+	defer c.Close()
+
+	slow := &blockingConsumer{
+		fakeConsumer: newFakeConsumer("slow"),
+		unblock:      make(chan struct{}),
+		disconnected: make(chan error, 1),
+	}
+	fast := newFakeConsumer("fast")
+	_, err = m.Process("slow", slow)
+	require.NoError(t, err)
+	_, err = m.Process("fast", fast)
+	require.NoError(t, err)
+
+	// overflow the queue of slow handler, paced to keep the ws client from dropping them
+	m.mu.Lock()
+	mh := m.handlers["slow"]
+	m.mu.Unlock()
+	for i := 1; i <= muxQueueSize+2; i++ {
+		send <- muxMsg("progress", "slow", "3")
+		if i%256 != 0 && i != muxQueueSize+2 {
+			continue
+		}
+		// the blocked handler holds one frame
+		sent := i
+		require.Eventually(t, func() bool {
+			return len(mh.queue)+int(mh.dropped.Load()) >= sent-1
+		}, time.Second, time.Millisecond)
+	}
+	send <- muxMsg("executing", "fast", nil)
+	require.Eventually(t, func() bool {
+		return len(fast.Frames()) == 1
+	}, time.Second, 10*time.Millisecond, "fast handler is held by the slow one")
+
+	close(slow.unblock)
+	select {
+	case err := <-slow.disconnected:
+		assert.ErrorIs(t, err, ErrWsMuxOverflow)
+	case <-time.After(time.Second):
+		t.Fatal("overflow is not reported")
+	}
+	close(slow.done)
+	close(fast.done)
+}
+
+func TestClient_Close(t *testing.T) {
+	srv, send, conns := muxServer(t)
+	defer srv.Close()
+	defer close(send)
+
+	c, err := New(Config{Endpoint: srv.URL})
+	require.NoError(t, err)
+	m, err := c.WsMux()
+	require.NoError(t, err)
+	require.NoError(t, c.Close())
+	select {
+	case <-m.Done():
+	case <-time.After(time.Second):
+		t.Fatal("mux is not closed")
+	}
+
+	// a new one is connected on demand
+	again, err := c.WsMux()
+	require.NoError(t, err)
+	assert.NotSame(t, m, again)
+	assert.Equal(t, int32(2), conns.Load())
+	require.NoError(t, c.Close())
+}
@@ -0,0 +1,215 @@
+package comfyuitest
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/sko00o/comfyui-go/ws/message"
+)
+
+// errStop ends the script without execution_success
+var errStop = errors.New("stop")
+
+// Step is one event of a scripted execution
+type Step func(e *Execution) error
+
+// Script is the events of a prompt, they are wrapped by
+// execution_start and execution_success, unless a step fails or interrupts
+type Script []Step
+
+// Execution is the state of a running prompt
+type Execution struct {
+	*Prompt
+	srv *Server
+
+	node     string
+	executed []string
+	outputs  map[string]any
+	messages [][2]any
+}
+
+// Node return the node executing now
+func (e *Execution) Node() string {
+	return e.node
+}
+
+// Send a text message to the client of the prompt
+func (e *Execution) Send(typ message.Type, data map[string]any) {
+	data["prompt_id"] = e.ID
+	switch typ {
+	case message.ExecutionStart, message.ExecutionCached, message.ExecutionSuccess,
+		message.ExecutionError, message.ExecutionInterrupted:
+		data["timestamp"] = time.Now().UnixMilli()
+		e.messages = append(e.messages, [2]any{typ, data})
+	}
+	p, _ := json.Marshal(map[string]any{"type": typ, "data": data})
+	e.srv.sendText(e.ClientID, p)
+}
+
+// SendBinary send a binary frame of event type to the client of the prompt
+func (e *Execution) SendBinary(eventType message.EventType, payload []byte) {
+	p := binary.BigEndian.AppendUint32(nil, uint32(eventType))
+	e.srv.sendBinary(e.ClientID, append(p, payload...))
+}
+
+// Executing start executing nodeID
+func Executing(nodeID string) Step {
+	return func(e *Execution) error {
+		e.node = nodeID
+		e.Send(message.Executing, map[string]any{"node": nodeID, "display_node": nodeID})
+		return nil
+	}
+}
+
+// Progress report the progress of the executing node
+func Progress(value, max int) Step {
+	return func(e *Execution) error {
+		e.Send(message.Progress, map[string]any{"value": value, "max": max, "node": e.node})
+		return nil
+	}
+}
+
+// Executed finish the executing node with output, nil output sends no executed message
+func Executed(output map[string]any) Step {
+	return func(e *Execution) error {
+		e.executed = append(e.executed, e.node)
+		if output == nil {
+			return nil
+		}
+		e.outputs[e.node] = output
+		e.Send(message.Executed, map[string]any{"node": e.node, "display_node": e.node, "output": output})
+		return nil
+	}
+}
+
+// Run execute nodeID with output
+func Run(nodeID string, output map[string]any) Step {
+	return func(e *Execution) error {
+		_ = Executing(nodeID)(e)
+		return Executed(output)(e)
+	}
+}
+
+// Images is the output of image nodes, e.g.: SaveImage
+func Images(files ...message.FileInfo) map[string]any {
+	return map[string]any{"images": files}
+}
+
+// Cached skip nodeIDs as cached
+func Cached(nodeIDs ...string) Step {
+	return func(e *Execution) error {
+		e.executed = append(e.executed, nodeIDs...)
+		e.Send(message.ExecutionCached, map[string]any{"nodes": nodeIDs})
+		return nil
+	}
+}
+
+// Preview send a PreviewImage binary frame
+func Preview(imageType message.ImageType, blob []byte) Step {
+	return func(e *Execution) error {
+		p := binary.BigEndian.AppendUint32(nil, uint32(imageType))
+		e.SendBinary(message.PreviewImage, append(p, blob...))
+		return nil
+	}
+}
+
+// Sleep pause the execution for d
+func Sleep(d time.Duration) Step {
+	return func(e *Execution) error {
+		time.Sleep(d)
+		return nil
+	}
+}
+
+// Disconnect drop the ws connection of the client
+func Disconnect() Step {
+	return func(e *Execution) error {
+		e.srv.Disconnect(e.ClientID)
+		return nil
+	}
+}
+
+// Fail the executing node with exception, e.g.: message.ExceptionTypeOOM
+func Fail(exceptionType, exceptionMessage string) Step {
+	return func(e *Execution) error {
+		e.Send(message.ExecutionError, map[string]any{
+			"node_id":           e.node,
+			"node_type":         e.classType(e.node),
+			"executed":          e.executed,
+			"exception_message": exceptionMessage,
+			"exception_type":    exceptionType,
+			"traceback":         []string{},
+			"current_inputs":    map[string]any{},
+			"current_outputs":   map[string]any{},
+		})
+		return fmt.Errorf("%s: %s", exceptionType, exceptionMessage)
+	}
+}
+
+// Interrupt the execution at the executing node
+func Interrupt() Step {
+	return func(e *Execution) error {
+		e.interrupt()
+		return errStop
+	}
+}
+
+func (e *Execution) interrupt() {
+	e.Send(message.ExecutionInterrupted, map[string]any{
+		"node_id":   e.node,
+		"node_type": e.classType(e.node),
+		"executed":  e.executed,
+	})
+}
+
+func (e *Execution) classType(nodeID string) string {
+	var n struct {
+		ClassType string `json:"class_type"`
+	}
+	_ = json.Unmarshal(e.Workflow[nodeID], &n)
+	return n.ClassType
+}
+
+// DefaultScript run the nodes in ID order, image nodes
+// ("SaveImage" and "PreviewImage") output a PNG registered in srv
+func DefaultScript(srv *Server, p *Prompt) Script {
+	ids := make([]string, 0, len(p.Workflow))
+	for id := range p.Workflow {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var script Script
+	e := &Execution{Prompt: p}
+	for _, id := range ids {
+		var output map[string]any
+		switch e.classType(id) {
+		case "SaveImage":
+			output = Images(srv.AddFile(message.FileInfo{
+				Filename: fmt.Sprintf("%s_%s.png", p.ID, id),
+				Type:     "output",
+			}, PNG))
+		case "PreviewImage":
+			output = Images(srv.AddFile(message.FileInfo{
+				Filename: fmt.Sprintf("%s_%s.png", p.ID, id),
+				Type:     "temp",
+			}, PNG))
+		}
+		script = append(script, Run(id, output))
+	}
+	return script
+}
+
+// PNG is a 1x1 transparent image
+var PNG = []byte{
+	0x89, 0x50, 0x4e, 0x47, 0x0d, 0x0a, 0x1a, 0x0a, 0x00, 0x00, 0x00, 0x0d,
+	0x49, 0x48, 0x44, 0x52, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01,
+	0x08, 0x06, 0x00, 0x00, 0x00, 0x1f, 0x15, 0xc4, 0x89, 0x00, 0x00, 0x00,
+	0x0d, 0x49, 0x44, 0x41, 0x54, 0x78, 0x9c, 0x63, 0x00, 0x01, 0x00, 0x00,
+	0x05, 0x00, 0x01, 0x0d, 0x0a, 0x2d, 0xb4, 0x00, 0x00, 0x00, 0x00, 0x49,
+	0x45, 0x4e, 0x44, 0xae, 0x42, 0x60, 0x82,
+}
@@ -0,0 +1,638 @@
+// Package comfyuitest provides an in-process fake ComfyUI server for tests,
+// prompts are executed by scripts which send ws messages like ComfyUI does.
+package comfyuitest
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gorilla/websocket"
+
+	comfyui "github.com/sko00o/comfyui-go"
+	"github.com/sko00o/comfyui-go/helper"
+	"github.com/sko00o/comfyui-go/ws/message"
+)
+
+// Prompt is a prompt submitted to the server
+type Prompt struct {
+	ID       string
+	Number   int
+	ClientID string
+	Workflow map[string]json.RawMessage
+
+	interrupted atomic.Bool
+}
+
+// Scripter return the script executing p
+type Scripter func(srv *Server, p *Prompt) Script
+
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	scripter Scripter
+	conns    map[string]*wsConn
+	prompts  []*Prompt
+	pending  []*Prompt
+	running  *Prompt
+	history  map[string]*historyEntry
+	order    []string
+	files    map[string][]byte
+	nodes    map[string]json.RawMessage
+	stats    comfyui.StatsResp
+	number   int
+
+	wake   chan struct{}
+	closed chan struct{}
+	wg     sync.WaitGroup
+
+	upgrader websocket.Upgrader
+}
+
+type Option func(s *Server)
+
+// WithScripter replace DefaultScript
+func WithScripter(fn Scripter) Option {
+	return func(s *Server) {
+		s.scripter = fn
+	}
+}
+
+// WithScript execute every prompt by script
+func WithScript(script ...Step) Option {
+	return WithScripter(func(*Server, *Prompt) Script {
+		return script
+	})
+}
+
+// WithObjectInfo serve nodes as object_info, prompts with other node types are rejected
+func WithObjectInfo(nodes map[string]json.RawMessage) Option {
+	return func(s *Server) {
+		s.nodes = nodes
+	}
+}
+
+func WithStats(stats comfyui.StatsResp) Option {
+	return func(s *Server) {
+		s.stats = stats
+	}
+}
+
+// NewServer start a fake ComfyUI, use its URL as the endpoint
+func NewServer(opts ...Option) *Server {
+	s := &Server{
+		scripter: DefaultScript,
+		conns:    make(map[string]*wsConn),
+		history:  make(map[string]*historyEntry),
+		files:    make(map[string][]byte),
+		stats: comfyui.StatsResp{
+			System: comfyui.SystemInfo{
+				OS:             "posix",
+				RAMTotal:       32 << 30,
+				RAMFree:        24 << 30,
+				ComfyUIVersion: "0.0.0-fake",
+			},
+			Devices: []comfyui.DeviceInfo{{
+				Name:           "cuda:0 Fake GPU",
+				Type:           "cuda",
+				VRAMTotal:      24 << 30,
+				VRAMFree:       20 << 30,
+				TorchVRAMTotal: 1 << 30,
+				TorchVRAMFree:  1 << 30,
+			}},
+		},
+		wake:   make(chan struct{}, 1),
+		closed: make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /ws", s.handleWS)
+	mux.HandleFunc("GET /api/prompt", s.handleGetPrompt)
+	mux.HandleFunc("POST /api/prompt", s.handlePostPrompt)
+	mux.HandleFunc("GET /api/queue", s.handleGetQueue)
+	mux.HandleFunc("POST /api/queue", s.handlePostQueue)
+	mux.HandleFunc("POST /api/interrupt", s.handleInterrupt)
+	mux.HandleFunc("GET /api/history", s.handleGetHistory)
+	mux.HandleFunc("GET /api/history/{id}", s.handleGetHistory)
+	mux.HandleFunc("POST /api/history", s.handlePostHistory)
+	mux.HandleFunc("GET /api/view", s.handleView)
+	mux.HandleFunc("GET /api/system_stats", s.handleStats)
+	mux.HandleFunc("GET /api/object_info", s.handleObjectInfo)
+	mux.HandleFunc("GET /api/object_info/{node}", s.handleObjectInfo)
+	s.Server = httptest.NewServer(mux)
+
+	s.wg.Add(1)
+	go s.worker()
+	return s
+}
+
+// Client return a client of the server
+func (s *Server) Client(opts ...comfyui.Option) *comfyui.Client {
+	c, err := comfyui.New(comfyui.Config{Endpoint: s.URL}, opts...)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+func (s *Server) Close() {
+	close(s.closed)
+	s.wg.Wait()
+	s.mu.Lock()
+	for _, c := range s.conns {
+		_ = c.Close()
+	}
+	s.mu.Unlock()
+	s.Server.Close()
+}
+
+// Disconnect drop the ws connection of clientID, all connections if it is empty,
+// the messages are lost until the client reconnects
+func (s *Server) Disconnect(clientID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, c := range s.conns {
+		if clientID == "" || id == clientID {
+			_ = c.Close()
+			delete(s.conns, id)
+		}
+	}
+}
+
+// AddFile serve content as f from /api/view
+func (s *Server) AddFile(f message.FileInfo, content []byte) message.FileInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[fileKey(f.Type, f.Subfolder, f.Filename)] = content
+	return f
+}
+
+func fileKey(typ, subfolder, filename string) string {
+	return path.Join(typ, subfolder, filename)
+}
+
+// Prompts return the prompts submitted
+func (s *Server) Prompts() []*Prompt {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*Prompt(nil), s.prompts...)
+}
+
+type wsConn struct {
+	*websocket.Conn
+	mu sync.Mutex
+}
+
+func (c *wsConn) write(messageType int, p []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.WriteMessage(messageType, p)
+}
+
+func (s *Server) handleWS(w http.ResponseWriter, r *http.Request) {
+	clientID := r.URL.Query().Get("clientId")
+	if clientID == "" {
+		clientID = helper.NewID()
+	}
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	c := &wsConn{Conn: conn}
+
+	s.mu.Lock()
+	if old, ok := s.conns[clientID]; ok {
+		_ = old.Close()
+	}
+	s.conns[clientID] = c
+	status := s.statusLocked(clientID)
+	s.mu.Unlock()
+	_ = c.write(websocket.TextMessage, status)
+
+	defer func() {
+		s.mu.Lock()
+		if s.conns[clientID] == c {
+			delete(s.conns, clientID)
+		}
+		s.mu.Unlock()
+		_ = conn.Close()
+	}()
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
+
+func (s *Server) statusLocked(sid string) []byte {
+	data := map[string]any{
+		"status": map[string]any{
+			"exec_info": map[string]any{"queue_remaining": s.queueRemainingLocked()},
+		},
+	}
+	if sid != "" {
+		data["sid"] = sid
+	}
+	p, _ := json.Marshal(map[string]any{"type": message.Status, "data": data})
+	return p
+}
+
+func (s *Server) queueRemainingLocked() int {
+	n := len(s.pending)
+	if s.running != nil {
+		n++
+	}
+	return n
+}
+
+func (s *Server) sendText(clientID string, p []byte) {
+	s.send(clientID, websocket.TextMessage, p)
+}
+
+func (s *Server) sendBinary(clientID string, p []byte) {
+	s.send(clientID, websocket.BinaryMessage, p)
+}
+
+// send to clientID, or all clients if it is empty like ComfyUI
+func (s *Server) send(clientID string, messageType int, p []byte) {
+	s.mu.Lock()
+	var conns []*wsConn
+	if clientID == "" {
+		for _, c := range s.conns {
+			conns = append(conns, c)
+		}
+	} else if c, ok := s.conns[clientID]; ok {
+		conns = append(conns, c)
+	}
+	s.mu.Unlock()
+	for _, c := range conns {
+		_ = c.write(messageType, p)
+	}
+}
+
+func (s *Server) broadcastStatus() {
+	s.mu.Lock()
+	p := s.statusLocked("")
+	s.mu.Unlock()
+	s.sendText("", p)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func (s *Server) handleGetPrompt(w http.ResponseWriter, _ *http.Request) {
+	s.mu.Lock()
+	n := s.queueRemainingLocked()
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, comfyui.GetPromptResp{ExecInfo: comfyui.ExecInfo{QueueRemaining: n}})
+}
+
+func (s *Server) handlePostPrompt(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Prompt   map[string]json.RawMessage `json:"prompt"`
+		ClientID string                     `json:"client_id"`
+		PromptID string                     `json:"prompt_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, promptError("invalid_prompt", "Invalid prompt", err.Error()))
+		return
+	}
+	if len(req.Prompt) == 0 {
+		writeJSON(w, http.StatusBadRequest, promptError("no_prompt", "No prompt provided", ""))
+		return
+	}
+	if err := s.validate(req.Prompt); err != nil {
+		writeJSON(w, http.StatusBadRequest, err)
+		return
+	}
+
+	p := &Prompt{
+		ID:       req.PromptID,
+		ClientID: req.ClientID,
+		Workflow: req.Prompt,
+	}
+	if p.ID == "" {
+		p.ID = helper.NewID()
+	}
+	s.mu.Lock()
+	p.Number = s.number
+	s.number++
+	s.prompts = append(s.prompts, p)
+	s.pending = append(s.pending, p)
+	s.mu.Unlock()
+	s.notify()
+	s.broadcastStatus()
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"prompt_id":   p.ID,
+		"number":      p.Number,
+		"node_errors": map[string]any{},
+	})
+}
+
+func promptError(typ, msg, details string) map[string]any {
+	return map[string]any{
+		"error": map[string]any{
+			"type":       typ,
+			"message":    msg,
+			"details":    details,
+			"extra_info": map[string]any{},
+		},
+		"node_errors": map[string]any{},
+	}
+}
+
+// validate the node types if object_info is set
+func (s *Server) validate(workflow map[string]json.RawMessage) map[string]any {
+	if s.nodes == nil {
+		return nil
+	}
+	for id, raw := range workflow {
+		var n struct {
+			ClassType string `json:"class_type"`
+		}
+		if err := json.Unmarshal(raw, &n); err != nil || n.ClassType == "" {
+			return promptError("invalid_prompt", "Cannot execute because a node is missing the class_type property.", "Node ID '#"+id+"'")
+		}
+		if _, ok := s.nodes[n.ClassType]; !ok {
+			return promptError("missing_node_type", fmt.Sprintf("Node '%s' not found. The custom node may not be installed.", n.ClassType), "Node ID '#"+id+"'")
+		}
+	}
+	return nil
+}
+
+func (s *Server) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Server) handleGetQueue(w http.ResponseWriter, _ *http.Request) {
+	s.mu.Lock()
+	running := []any{}
+	if s.running != nil {
+		running = append(running, promptArray(s.running))
+	}
+	pending := []any{}
+	for _, p := range s.pending {
+		pending = append(pending, promptArray(p))
+	}
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]any{
+		"queue_running": running,
+		"queue_pending": pending,
+	})
+}
+
+func promptArray(p *Prompt) []any {
+	outputs := []string{}
+	return []any{p.Number, p.ID, p.Workflow, map[string]any{"client_id": p.ClientID}, outputs}
+}
+
+func (s *Server) handlePostQueue(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Delete []string `json:"delete"`
+		Clear  bool     `json:"clear"`
+	}
+	_ = json.NewDecoder(r.Body).Decode(&req)
+	s.mu.Lock()
+	if req.Clear {
+		s.pending = nil
+	}
+	for _, id := range req.Delete {
+		for i, p := range s.pending {
+			if p.ID == id {
+				s.pending = append(s.pending[:i], s.pending[i+1:]...)
+				break
+			}
+		}
+	}
+	s.mu.Unlock()
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) handleInterrupt(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		PromptID string `json:"prompt_id"`
+	}
+	_ = json.NewDecoder(r.Body).Decode(&req)
+	s.mu.Lock()
+	if s.running != nil && (req.PromptID == "" || req.PromptID == s.running.ID) {
+		s.running.interrupted.Store(true)
+	}
+	s.mu.Unlock()
+	w.WriteHeader(http.StatusOK)
+}
+
+type historyEntry struct {
+	prompt   *Prompt
+	outputs  map[string]any
+	status   string
+	messages [][2]any
+}
+
+func (h *historyEntry) MarshalJSON() ([]byte, error) {
+	outputIDs := make([]string, 0, len(h.outputs))
+	for id := range h.outputs {
+		outputIDs = append(outputIDs, id)
+	}
+	return json.Marshal(map[string]any{
+		"prompt":  []any{h.prompt.Number, h.prompt.ID, h.prompt.Workflow, map[string]any{"client_id": h.prompt.ClientID}, outputIDs},
+		"outputs": h.outputs,
+		"status": map[string]any{
+			"status_str": h.status,
+			"completed":  h.status == comfyui.StatusStrSuccess,
+			"messages":   h.messages,
+		},
+		"meta": map[string]any{},
+	})
+}
+
+func (s *Server) handleGetHistory(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	resp := make(map[string]*historyEntry)
+	if id := r.PathValue("id"); id != "" {
+		if h, ok := s.history[id]; ok {
+			resp[id] = h
+		}
+		writeJSON(w, http.StatusOK, resp)
+		return
+	}
+
+	ids := s.order
+	if v, err := strconv.Atoi(r.URL.Query().Get("offset")); err == nil && v >= 0 {
+		ids = ids[min(v, len(ids)):]
+	}
+	if v, err := strconv.Atoi(r.URL.Query().Get("max_items")); err == nil && v >= 0 {
+		ids = ids[:min(v, len(ids))]
+	}
+	for _, id := range ids {
+		resp[id] = s.history[id]
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) handlePostHistory(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Delete []string `json:"delete"`
+		Clear  bool     `json:"clear"`
+	}
+	_ = json.NewDecoder(r.Body).Decode(&req)
+	s.mu.Lock()
+	if req.Clear {
+		req.Delete = s.order
+	}
+	for _, id := range req.Delete {
+		delete(s.history, id)
+	}
+	order := s.order[:0:0]
+	for _, id := range s.order {
+		if _, ok := s.history[id]; ok {
+			order = append(order, id)
+		}
+	}
+	s.order = order
+	s.mu.Unlock()
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) handleView(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	typ := q.Get("type")
+	if typ == "" {
+		typ = "output"
+	}
+	filename := q.Get("filename")
+	s.mu.Lock()
+	content, ok := s.files[fileKey(typ, q.Get("subfolder"), filename)]
+	s.mu.Unlock()
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if ct := mime.TypeByExtension(filepath.Ext(filename)); ct != "" {
+		w.Header().Set("Content-Type", ct)
+	}
+	_, _ = w.Write(content)
+}
+
+func (s *Server) handleStats(w http.ResponseWriter, _ *http.Request) {
+	s.mu.Lock()
+	stats := s.stats
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, stats)
+}
+
+func (s *Server) handleObjectInfo(w http.ResponseWriter, r *http.Request) {
+	nodes := s.nodes
+	if nodes == nil {
+		nodes = map[string]json.RawMessage{}
+	}
+	if name := r.PathValue("node"); name != "" {
+		resp := map[string]json.RawMessage{}
+		if info, ok := nodes[name]; ok {
+			resp[name] = info
+		}
+		writeJSON(w, http.StatusOK, resp)
+		return
+	}
+	writeJSON(w, http.StatusOK, nodes)
+}
+
+// worker execute the prompts one by one like ComfyUI
+func (s *Server) worker() {
+	defer s.wg.Done()
+	for {
+		select {
+		case <-s.closed:
+			return
+		case <-s.wake:
+		}
+		for s.runNext() {
+		}
+	}
+}
+
+func (s *Server) runNext() bool {
+	s.mu.Lock()
+	if len(s.pending) == 0 {
+		s.mu.Unlock()
+		return false
+	}
+	p := s.pending[0]
+	s.pending = s.pending[1:]
+	s.running = p
+	scripter := s.scripter
+	s.mu.Unlock()
+
+	s.execute(p, scripter(s, p))
+
+	s.mu.Lock()
+	s.running = nil
+	s.mu.Unlock()
+	s.broadcastStatus()
+	return true
+}
+
+func (s *Server) execute(p *Prompt, script Script) {
+	e := &Execution{
+		Prompt:  p,
+		srv:     s,
+		outputs: make(map[string]any),
+	}
+	e.Send(message.ExecutionStart, map[string]any{})
+
+	status := comfyui.StatusStrSuccess
+	var err error
+	for _, step := range script {
+		select {
+		case <-s.closed:
+			err = errStop
+		default:
+		}
+		if err == nil && p.interrupted.Load() {
+			e.interrupt()
+			err = errStop
+		}
+		if err == nil {
+			err = step(e)
+		}
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		e.Send(message.ExecutionSuccess, map[string]any{})
+	} else {
+		// interrupted prompt is also an error in history
+		status = comfyui.StatusStrError
+	}
+
+	// history is stored before the final message, like ComfyUI does
+	s.mu.Lock()
+	s.history[p.ID] = &historyEntry{
+		prompt:   p,
+		outputs:  e.outputs,
+		status:   status,
+		messages: e.messages,
+	}
+	s.order = append(s.order, p.ID)
+	s.mu.Unlock()
+	e.Send(message.Executing, map[string]any{"node": nil})
+}
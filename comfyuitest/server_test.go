@@ -0,0 +1,532 @@
+package comfyuitest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	comfyui "github.com/sko00o/comfyui-go"
+	comfyError "github.com/sko00o/comfyui-go/error"
+	"github.com/sko00o/comfyui-go/logger"
+	"github.com/sko00o/comfyui-go/session"
+	"github.com/sko00o/comfyui-go/ws"
+	"github.com/sko00o/comfyui-go/ws/message"
+)
+
+var workflow = map[string]any{
+	"prompt": map[string]any{
+		"3": map[string]any{"class_type": "KSampler", "inputs": map[string]any{}},
+		"9": map[string]any{"class_type": "SaveImage", "inputs": map[string]any{}},
+	},
+	"client_id": "c1",
+}
+
+type frame struct {
+	messageType int
+	data        []byte
+}
+
+func dial(t *testing.T, cli *comfyui.Client, clientID string) <-chan frame {
+	frames := make(chan frame, 100)
+	wc, err := ws.New(cli.BaseURL, clientID, ws.HandlerFunc(func(messageType int, p []byte) {
+		frames <- frame{messageType, p}
+	}), logger.NewStd())
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = wc.Close() })
+
+	// the status on connect
+	f := <-frames
+	var m message.Message
+	require.NoError(t, json.Unmarshal(f.data, &m))
+	require.Equal(t, message.Status, m.Type)
+	assert.Equal(t, clientID, *m.Data.(*message.DataStatus).SID)
+	return frames
+}
+
+// collect the messages of promptID until executing with null node
+func collect(t *testing.T, frames <-chan frame, promptID string) (types []message.Type, binaries [][]byte) {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case f := <-frames:
+			if f.messageType == websocket.BinaryMessage {
+				binaries = append(binaries, f.data)
+				continue
+			}
+			var m message.Message
+			require.NoError(t, json.Unmarshal(f.data, &m))
+			if m.Data == nil || m.Data.GetPromptID() != promptID {
+				continue
+			}
+			types = append(types, m.Type)
+			if o, ok := m.Data.(*message.DataExecuting); ok && o.Node == nil {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("timeout, got %v", types)
+		}
+	}
+}
+
+func TestServer_DefaultScript(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	cli := srv.Client()
+	frames := dial(t, cli, "c1")
+
+	resp, err := cli.Prompt(workflow)
+	require.NoError(t, err)
+	types, _ := collect(t, frames, resp.PromptID)
+	assert.Equal(t, []message.Type{
+		message.ExecutionStart,
+		message.Executing, message.Executing, message.Executed,
+		message.ExecutionSuccess, message.Executing,
+	}, types)
+
+	h, err := cli.GetHistoryByID(resp.PromptID)
+	require.NoError(t, err)
+	assert.Equal(t, comfyui.StatusStrSuccess, h.Status.StatusStr)
+	assert.Equal(t, resp.PromptID, h.Prompt.PromptID)
+
+	var output struct {
+		Images []message.FileInfo `json:"images"`
+	}
+	require.NoError(t, json.Unmarshal(h.Outputs["9"], &output))
+	require.Len(t, output.Images, 1)
+	files := output.Images
+	require.NoError(t, cli.GetView(files[0], func(rd io.Reader, header http.Header) error {
+		p, err := io.ReadAll(rd)
+		assert.Equal(t, PNG, p)
+		assert.Equal(t, "image/png", header.Get("Content-Type"))
+		return err
+	}))
+}
+
+func TestServer_Script(t *testing.T) {
+	srv := NewServer(WithScript(
+		Cached("1", "2"),
+		Executing("3"),
+		Progress(1, 2),
+		Preview(message.JPEG, []byte("jpeg")),
+		Progress(2, 2),
+		Executed(nil),
+		Executing("9"),
+		Fail(message.ExceptionTypeOOM, "Allocation on device"),
+	))
+	defer srv.Close()
+	cli := srv.Client()
+	frames := dial(t, cli, "c1")
+
+	resp, err := cli.Prompt(workflow)
+	require.NoError(t, err)
+	types, binaries := collect(t, frames, resp.PromptID)
+	assert.Equal(t, []message.Type{
+		message.ExecutionStart, message.ExecutionCached,
+		message.Executing, message.Progress, message.Progress,
+		message.Executing, message.ExecutionError, message.Executing,
+	}, types)
+	require.Len(t, binaries, 1)
+	var b message.BinaryMessage
+	require.NoError(t, b.UnmarshalBinary(binaries[0]))
+	assert.Equal(t, []byte("jpeg"), b.Data.(*message.DataImage).Blob)
+
+	h, err := cli.GetHistoryByID(resp.PromptID)
+	require.NoError(t, err)
+	assert.True(t, h.Status.IsError())
+}
+
+func TestServer_Interrupt(t *testing.T) {
+	srv := NewServer(WithScript(Executing("3"), Sleep(time.Second), Run("9", nil)))
+	defer srv.Close()
+	cli := srv.Client()
+	frames := dial(t, cli, "c1")
+
+	first, err := cli.Prompt(workflow)
+	require.NoError(t, err)
+	second, err := cli.Prompt(workflow)
+	require.NoError(t, err)
+
+	q, err := cli.GetQueue()
+	require.NoError(t, err)
+	assert.True(t, q.IsRunning(first.PromptID))
+	assert.True(t, q.IsPending(second.PromptID))
+
+	require.NoError(t, cli.DeleteFromQueue(second.PromptID))
+	require.NoError(t, cli.Interrupt(first.PromptID))
+	types, _ := collect(t, frames, first.PromptID)
+	assert.Equal(t, message.ExecutionInterrupted, types[len(types)-2])
+
+	_, err = cli.GetHistoryByID(second.PromptID)
+	assert.ErrorIs(t, err, comfyui.ErrHistoryNotFound)
+}
+
+func TestServer_ObjectInfo(t *testing.T) {
+	srv := NewServer(WithObjectInfo(map[string]json.RawMessage{
+		"SaveImage": json.RawMessage(`{"name":"SaveImage","output_node":true}`),
+	}))
+	defer srv.Close()
+	cli := srv.Client()
+
+	info, err := cli.NodeInfo(context.Background(), "SaveImage")
+	require.NoError(t, err)
+	assert.True(t, info.OutputNode)
+
+	_, err = cli.Prompt(workflow)
+	assert.ErrorIs(t, err, comfyError.ErrMissingNodeType)
+}
+
+type memSaver struct {
+	files map[string][]byte
+}
+
+func (m *memSaver) Save(rd io.Reader, destPath string, _ string) error {
+	var buf bytes.Buffer
+	_, err := buf.ReadFrom(rd)
+	m.files[destPath] = buf.Bytes()
+	return err
+}
+
+func TestServer_Session(t *testing.T) {
+	srv := NewServer(WithScripter(func(srv *Server, p *Prompt) Script {
+		// give the caller time to store the resp before messages
+		return append(Script{Sleep(100 * time.Millisecond)}, DefaultScript(srv, p)...)
+	}))
+	defer srv.Close()
+	cli := srv.Client()
+
+	saver := &memSaver{files: make(map[string][]byte)}
+	nameCh := map[string]chan string{"9": make(chan string, 1)}
+	sess := session.New("t1", "c1", "", map[string]string{"9": "out"}, nameCh, nil,
+		template.Must(template.New("").Parse("{{ .PromptID }}{{ .EXT }}")), 2, nil, 1,
+		logger.NewStd(), cli, saver)
+	wg, err := cli.SimpleProcess("c1", &session.WrapSession{Session: sess})
+	require.NoError(t, err)
+
+	resp, err := cli.Prompt(workflow)
+	require.NoError(t, err)
+	sess.Track(*resp)
+
+	// read the state while it is changed by the ws goroutine
+	stop := make(chan struct{})
+	readerDone := make(chan struct{})
+	go func() {
+		defer close(readerDone)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			snap := sess.Snapshot()
+			for id := range snap.NodesTime {
+				snap.NodesTime[id] = 0
+			}
+		}
+	}()
+	res := sess.Wait(5 * time.Second)
+	close(stop)
+	<-readerDone
+	wg.Wait()
+
+	assert.Empty(t, res[resp.PromptID].Errs)
+	assert.Equal(t, session.PromptSucceeded, res[resp.PromptID].State)
+	assert.Equal(t, resp.PromptID+".png", <-nameCh["9"])
+	assert.Equal(t, PNG, saver.files["out/"+resp.PromptID+".png"])
+	assert.Contains(t, sess.NodesTime, "9")
+}
+
+func TestServer_SessionState(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		script Script
+		state  session.PromptState
+		errs   int
+	}{
+		{name: "cached", script: Script{Cached("1", "2", "3", "9")}, state: session.PromptCached},
+		{name: "failed", script: Script{Executing("3"), Fail(message.ExceptionTypeOOM, "Allocation on device")}, state: session.PromptFailed, errs: 1},
+		{name: "interrupted", script: Script{Executing("3"), Interrupt()}, state: session.PromptInterrupted, errs: 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			// the messages may arrive before Track
+			srv := NewServer(WithScript(tc.script...))
+			defer srv.Close()
+			cli := srv.Client()
+
+			sess := session.New("t1", "c1", "", nil, nil, nil, nil, 4, nil, 1,
+				logger.NewStd(), cli, nil)
+			wg, err := cli.SimpleProcess("c1", &session.WrapSession{Session: sess})
+			require.NoError(t, err)
+
+			resp, err := cli.Prompt(workflow)
+			require.NoError(t, err)
+			// the deprecated ErrorChan still receives the errors
+			errCh := make(chan error)
+			sess.StoreResp(resp.PromptID, session.RespResult{QPResp: *resp, ErrorChan: errCh})
+			_, ok := sess.PromptState(resp.PromptID)
+			require.True(t, ok)
+
+			res := sess.Wait(5 * time.Second)
+			wg.Wait()
+			assert.Equal(t, tc.state, res[resp.PromptID].State)
+			assert.Len(t, res[resp.PromptID].Errs, tc.errs)
+			var chErrs []error
+			for err := range errCh {
+				chErrs = append(chErrs, err)
+			}
+			assert.Equal(t, res[resp.PromptID].Errs, chErrs)
+			assert.Equal(t, tc.state, sess.Snapshot().Prompts[resp.PromptID].State)
+		})
+	}
+}
+
+func TestServer_SessionDisconnect(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		reconnect ws.ReconnectConfig
+	}{
+		{name: "reconnect", reconnect: ws.ReconnectConfig{InitialBackoff: 10 * time.Millisecond}},
+		{name: "give up", reconnect: ws.ReconnectConfig{Disable: true}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := NewServer(WithScripter(func(srv *Server, p *Prompt) Script {
+				// the outputs are only in history
+				return append(Script{Sleep(100 * time.Millisecond), Disconnect()}, DefaultScript(srv, p)...)
+			}))
+			defer srv.Close()
+			cli := srv.Client(comfyui.WithWsOptions(ws.WithReconnect(tc.reconnect)))
+
+			saver := &memSaver{files: make(map[string][]byte)}
+			nameCh := map[string]chan string{"9": make(chan string, 1)}
+			sess := session.New("t1", "c1", "", map[string]string{"9": "out"}, nameCh, nil,
+				template.Must(template.New("").Parse("{{ .PromptID }}{{ .EXT }}")), 2, nil, 1,
+				logger.NewStd(), cli, saver)
+			sess.HistoryPollInterval = 50 * time.Millisecond
+			wg, err := cli.SimpleProcess("c1", &session.WrapSession{Session: sess})
+			require.NoError(t, err)
+
+			resp, err := cli.Prompt(workflow)
+			require.NoError(t, err)
+			sess.Track(*resp)
+			res := sess.Wait(5 * time.Second)
+			wg.Wait()
+
+			assert.Empty(t, res[resp.PromptID].Errs)
+			// recovered from history, the nodes are executed though no executing message is received
+			assert.Equal(t, session.PromptSucceeded, res[resp.PromptID].State)
+			assert.Equal(t, resp.PromptID+".png", <-nameCh["9"])
+			assert.Equal(t, PNG, saver.files["out/"+resp.PromptID+".png"])
+		})
+	}
+}
+
+func TestServer_SessionCancel(t *testing.T) {
+	t.Run("running", func(t *testing.T) {
+		srv := NewServer(WithScripter(func(srv *Server, p *Prompt) Script {
+			file := srv.AddFile(message.FileInfo{Filename: p.ID + "_9.png", Type: "output"}, PNG)
+			// the interrupt is checked between steps, it stops before the long one
+			return Script{Run("9", Images(file)), Executing("3"), Sleep(200 * time.Millisecond), Sleep(5 * time.Second)}
+		}))
+		defer srv.Close()
+		cli := srv.Client()
+
+		saver := &memSaver{files: make(map[string][]byte)}
+		nameCh := map[string]chan string{"9": make(chan string, 1)}
+		sess := session.New("t1", "c1", "", map[string]string{"9": "out"}, nameCh, nil,
+			template.Must(template.New("").Parse("{{ .PromptID }}{{ .EXT }}")), 2, nil, 1,
+			logger.NewStd(), cli, saver)
+		sess.HistoryPollInterval = 50 * time.Millisecond
+		wg, err := cli.SimpleProcess("c1", &session.WrapSession{Session: sess})
+		require.NoError(t, err)
+
+		resp, err := cli.Prompt(workflow)
+		require.NoError(t, err)
+		sess.Track(*resp)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			// cancel after the output is saved
+			<-nameCh["9"]
+			cancel()
+		}()
+		start := time.Now()
+		err = sess.Run(ctx)
+		wg.Wait()
+		assert.Less(t, time.Since(start), 5*time.Second)
+
+		var cancelErr *session.CanceledError
+		require.ErrorAs(t, err, &cancelErr)
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.NotErrorIs(t, err, session.ErrTimeout)
+		assert.Equal(t, session.PromptInterrupted, cancelErr.State)
+		assert.Equal(t, map[string][]string{"9": {resp.PromptID + ".png"}}, cancelErr.Files)
+		assert.Contains(t, cancelErr.NodesTime, "9")
+		p, _ := sess.PromptState(resp.PromptID)
+		assert.Equal(t, session.PromptInterrupted, p.State)
+	})
+
+	t.Run("queued", func(t *testing.T) {
+		srv := NewServer(WithScript(Executing("3"), Sleep(time.Second)))
+		defer srv.Close()
+		cli := srv.Client()
+
+		first, err := cli.Prompt(workflow)
+		require.NoError(t, err)
+
+		sess := session.New("t1", "c1", "", nil, nil, nil, nil, 2, nil, 1,
+			logger.NewStd(), cli, nil)
+		sess.HistoryPollInterval = 50 * time.Millisecond
+		wg, err := cli.SimpleProcess("c1", &session.WrapSession{Session: sess})
+		require.NoError(t, err)
+		resp, err := cli.Prompt(workflow)
+		require.NoError(t, err)
+		sess.Track(*resp)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		res := sess.WaitContext(ctx)
+		wg.Wait()
+
+		var cancelErr *session.CanceledError
+		require.ErrorAs(t, errors.Join(res[resp.PromptID].Errs...), &cancelErr)
+		assert.ErrorIs(t, cancelErr, context.DeadlineExceeded)
+		assert.ErrorIs(t, cancelErr, session.ErrTimeout)
+		assert.Equal(t, session.PromptInterrupted, res[resp.PromptID].State)
+
+		q, err := cli.GetQueue()
+		require.NoError(t, err)
+		assert.False(t, q.IsPending(resp.PromptID))
+		assert.True(t, q.IsRunning(first.PromptID))
+	})
+	t.Run("drain", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		srv := NewServer(WithScripter(func(srv *Server, p *Prompt) Script {
+			file := srv.AddFile(message.FileInfo{Filename: p.ID + "_9.png", Type: "output"}, PNG)
+			return Script{Sleep(100 * time.Millisecond), func(e *Execution) error {
+				// the output comes after the session context is canceled
+				cancel()
+				return Run("9", Images(file))(e)
+			}}
+		}))
+		defer srv.Close()
+		cli := srv.Client()
+
+		saver := &memSaver{files: make(map[string][]byte)}
+		sess := session.New("t1", "c1", "", map[string]string{"9": "out"}, nil, nil,
+			template.Must(template.New("").Parse("{{ .PromptID }}{{ .EXT }}")), 2, nil, 1,
+			logger.NewStd(), cli, saver)
+		sess.SetContext(ctx)
+		sess.HistoryPollInterval = 50 * time.Millisecond
+		wg, err := cli.SimpleProcess("c1", &session.WrapSession{Session: sess})
+		require.NoError(t, err)
+
+		resp, err := cli.Prompt(workflow)
+		require.NoError(t, err)
+		sess.Track(*resp)
+
+		require.NoError(t, sess.Run(ctx))
+		wg.Wait()
+		p, _ := sess.PromptState(resp.PromptID)
+		assert.Equal(t, session.PromptSucceeded, p.State)
+		assert.Equal(t, map[string][]string{"9": {resp.PromptID + ".png"}}, p.Files)
+	})
+}
+
+func TestClient_Events(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	cli := srv.Client()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events, err := cli.Events(ctx, "c1")
+	require.NoError(t, err)
+	ev := <-events
+	require.Equal(t, message.Status, ev.Type)
+	assert.Equal(t, "c1", *ev.Data.(*message.DataStatus).SID)
+
+	resp, err := cli.Prompt(workflow)
+	require.NoError(t, err)
+	for ev := range events {
+		if ev.PromptID != resp.PromptID {
+			continue
+		}
+		if o, ok := ev.Data.(*message.DataExecuted); ok {
+			assert.Equal(t, "9", *o.Node)
+			assert.Contains(t, o.Output, "images")
+		}
+		if ev.IsFinal() {
+			break
+		}
+	}
+
+	cancel()
+	for range events {
+	}
+}
+
+func TestClient_Watch(t *testing.T) {
+	srv := NewServer(WithScript(
+		Executing("3"),
+		Progress(1, 2),
+		Preview(message.JPEG, []byte("jpeg")),
+		Progress(2, 2),
+		Executed(nil),
+		Executing("9"),
+		Interrupt(),
+	))
+	defer srv.Close()
+	cli := srv.Client()
+	mux, err := cli.WsMux()
+	require.NoError(t, err)
+	defer mux.Close()
+
+	data := map[string]any{"prompt": workflow["prompt"], "client_id": mux.ClientID()}
+	resp, err := cli.Prompt(data)
+	require.NoError(t, err)
+	events, err := cli.Watch(context.Background(), resp.PromptID)
+	require.NoError(t, err)
+
+	var (
+		types  []message.Type
+		blobs  [][]byte
+		values []int
+	)
+	timeout := time.After(5 * time.Second)
+	for done := false; !done; {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				done = true
+				break
+			}
+			assert.Equal(t, resp.PromptID, ev.PromptID)
+			if ev.Binary != nil {
+				blobs = append(blobs, ev.Binary.Data.(*message.DataImage).Blob)
+				continue
+			}
+			types = append(types, ev.Type)
+			if o, ok := ev.Data.(*message.DataProgress); ok {
+				values = append(values, o.Value)
+			}
+		case <-timeout:
+			t.Fatalf("not closed, got %v", types)
+		}
+	}
+	assert.Equal(t, []message.Type{
+		message.ExecutionStart,
+		message.Executing, message.Progress, message.Progress,
+		message.Executing, message.ExecutionInterrupted,
+	}, types)
+	assert.Equal(t, [][]byte{[]byte("jpeg")}, blobs)
+	assert.Equal(t, []int{1, 2}, values)
+}
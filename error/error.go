@@ -2,15 +2,135 @@ package error
 
 import (
 	"encoding/json"
+	"errors"
+	"path"
+	"strings"
 	"time"
 )
 
+var (
+	// ErrInvalidPrompt matches any prompt validation error
+	ErrInvalidPrompt = errors.New("invalid prompt")
+	// ErrMissingModel matches the model file not found in ComfyUI
+	ErrMissingModel = errors.New("missing model")
+	// ErrMissingNodeType matches the node type not installed in ComfyUI
+	ErrMissingNodeType = errors.New("missing node type")
+	// ErrInvalidInput matches the invalid input of any node
+	ErrInvalidInput = errors.New("invalid input")
+	// ErrOOM matches the out of memory error
+	ErrOOM = errors.New("out of memory")
+)
+
 type ComfyUIError struct {
 	Message   json.RawMessage
 	IsOOM     bool
 	NodesTime map[string]time.Duration
+	// StatusCode is the HTTP status of the response, 0 if the error is not from a response
+	StatusCode int
 }
 
 func (e ComfyUIError) Error() string {
 	return string(e.Message)
 }
+
+// PromptError parse Message as the response of invalid prompt
+func (e ComfyUIError) PromptError() (*PromptError, bool) {
+	var pe struct {
+		Error      *ValidationError `json:"error"`
+		NodeErrors NodeErrors       `json:"node_errors"`
+	}
+	if err := json.Unmarshal(e.Message, &pe); err != nil || pe.Error == nil {
+		return nil, false
+	}
+	return &PromptError{
+		Error:      *pe.Error,
+		NodeErrors: pe.NodeErrors,
+	}, true
+}
+
+// NodeErrors return the node errors of invalid prompt
+func (e ComfyUIError) NodeErrors() NodeErrors {
+	pe, ok := e.PromptError()
+	if !ok {
+		return nil
+	}
+	return pe.NodeErrors
+}
+
+// MissingModel reports whether any model input has a value not in ComfyUI
+func (e ComfyUIError) MissingModel() bool {
+	return len(e.MissingModels()) > 0
+}
+
+// MissingModels return the validation errors of model not found
+func (e ComfyUIError) MissingModels() []ValidationError {
+	var list []ValidationError
+	for _, ne := range e.NodeErrors().Sorted() {
+		for _, ve := range ne.Errors {
+			if ve.isMissingModel() {
+				list = append(list, ve)
+			}
+		}
+	}
+	return list
+}
+
+// InvalidInput return the node errors which have the invalid input name
+func (e ComfyUIError) InvalidInput(name string) []NodeError {
+	var list []NodeError
+	for _, ne := range e.NodeErrors().Sorted() {
+		for _, ve := range ne.Errors {
+			if ve.ExtraInfo.InputName == name {
+				list = append(list, ne)
+				break
+			}
+		}
+	}
+	return list
+}
+
+func (e ComfyUIError) Is(target error) bool {
+	switch target {
+	case ErrOOM:
+		return e.IsOOM
+	case ErrInvalidPrompt:
+		_, ok := e.PromptError()
+		return ok
+	case ErrMissingModel:
+		return e.MissingModel()
+	case ErrInvalidInput:
+		return len(e.NodeErrors()) > 0
+	case ErrMissingNodeType:
+		pe, ok := e.PromptError()
+		return ok && pe.Error.Type == TypeMissingNodeType
+	}
+	return false
+}
+
+var modelExts = []string{".safetensors", ".ckpt", ".pt", ".pth", ".bin", ".gguf", ".sft", ".onnx"}
+
+// model inputs of core nodes, clip_name has suffix in DualCLIPLoader
+var modelInputPrefixes = []string{
+	"ckpt_name", "lora_name", "vae_name", "unet_name", "clip_name",
+	"control_net_name", "style_model_name", "upscale_model_name",
+	"gligen_name", "hypernetwork_name", "model_name",
+}
+
+func (e ValidationError) isMissingModel() bool {
+	if e.Type != TypeValueNotInList {
+		return false
+	}
+	for _, prefix := range modelInputPrefixes {
+		if strings.HasPrefix(e.ExtraInfo.InputName, prefix) {
+			return true
+		}
+	}
+	if v, ok := e.ExtraInfo.ReceivedValue.(string); ok {
+		for _, ext := range modelExts {
+			if strings.EqualFold(path.Ext(v), ext) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -69,3 +69,104 @@ func TestMarshalJSON(t *testing.T) {
 		}
 	}
 }
+
+func TestComfyUIError_PromptError(t *testing.T) {
+	msg := json.RawMessage(`{
+  "error": {
+    "type": "prompt_outputs_failed_validation",
+    "message": "Prompt outputs failed validation",
+    "details": "",
+    "extra_info": {}
+  },
+  "node_errors": {
+    "4": {
+      "errors": [
+        {
+          "type": "value_not_in_list",
+          "message": "Value not in list",
+          "details": "ckpt_name: 'v1-5.safetensors' not in ['sd_xl_base_1.0.safetensors']",
+          "extra_info": {
+            "input_name": "ckpt_name",
+            "input_config": [["sd_xl_base_1.0.safetensors"], {}],
+            "received_value": "v1-5.safetensors"
+          }
+        }
+      ],
+      "dependent_outputs": ["9"],
+      "class_type": "CheckpointLoaderSimple"
+    },
+    "3": {
+      "errors": [
+        {
+          "type": "value_not_in_list",
+          "message": "Value not in list",
+          "details": "sampler_name: 'euler_x' not in ['euler']",
+          "extra_info": {
+            "input_name": "sampler_name",
+            "input_config": [["euler"], {}],
+            "received_value": "euler_x"
+          }
+        }
+      ],
+      "dependent_outputs": ["9"],
+      "class_type": "KSampler"
+    }
+  }
+}`)
+	err := fmt.Errorf("wrapped: %w", ComfyUIError{Message: msg})
+
+	var cErr ComfyUIError
+	if !assert.True(t, errors.As(err, &cErr)) {
+		return
+	}
+	pe, ok := cErr.PromptError()
+	assert.True(t, ok)
+	assert.Equal(t, TypePromptOutputsFailed, pe.Error.Type)
+	assert.Len(t, pe.NodeErrors, 2)
+	assert.Equal(t, "4", pe.NodeErrors["4"].NodeID)
+	assert.Equal(t, "CheckpointLoaderSimple", pe.NodeErrors["4"].ClassType)
+	assert.Equal(t, []string{"9"}, pe.NodeErrors["4"].DependentOutputs)
+
+	missing := cErr.MissingModels()
+	assert.Len(t, missing, 1)
+	assert.Equal(t, "ckpt_name", missing[0].ExtraInfo.InputName)
+	assert.Equal(t, "v1-5.safetensors", missing[0].ExtraInfo.ReceivedValue)
+
+	assert.Len(t, cErr.InvalidInput("sampler_name"), 1)
+	assert.Empty(t, cErr.InvalidInput("seed"))
+
+	assert.ErrorIs(t, err, ErrInvalidPrompt)
+	assert.ErrorIs(t, err, ErrMissingModel)
+	assert.ErrorIs(t, err, ErrInvalidInput)
+	assert.NotErrorIs(t, err, ErrMissingNodeType)
+	assert.NotErrorIs(t, err, ErrOOM)
+}
+
+func TestComfyUIError_PromptErrorString(t *testing.T) {
+	cErr := ComfyUIError{Message: json.RawMessage(`{"error": "no prompt", "node_errors": []}`)}
+	pe, ok := cErr.PromptError()
+	assert.True(t, ok)
+	assert.Equal(t, "no prompt", pe.Error.Message)
+	assert.Empty(t, pe.NodeErrors)
+	assert.NotErrorIs(t, cErr, ErrMissingModel)
+
+	// messages from ws are not prompt error
+	cErr = ComfyUIError{Message: json.RawMessage(`{"type":"execution_error","data":{}}`), IsOOM: true}
+	_, ok = cErr.PromptError()
+	assert.False(t, ok)
+	assert.ErrorIs(t, cErr, ErrOOM)
+}
+
+func TestNodeErrors_Sorted(t *testing.T) {
+	e := NodeErrors{
+		"10":  {NodeID: "10"},
+		"9":   {NodeID: "9"},
+		"5:1": {NodeID: "5:1"},
+		"2":   {NodeID: "2"},
+	}
+	var ids []string
+	for _, v := range e.Sorted() {
+		ids = append(ids, v.NodeID)
+	}
+	assert.Equal(t, []string{"2", "9", "10", "5:1"}, ids)
+}
@@ -0,0 +1,141 @@
+package error
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ValidationError is the prompt or node validation error from ComfyUI
+type ValidationError struct {
+	Type      string    `json:"type"`
+	Message   string    `json:"message"`
+	Details   string    `json:"details"`
+	ExtraInfo ExtraInfo `json:"extra_info"`
+}
+
+// known validation error types
+const (
+	TypeValueNotInList         = "value_not_in_list"
+	TypeRequiredInputMissing   = "required_input_missing"
+	TypeMissingNodeType        = "missing_node_type"
+	TypePromptNoOutputs        = "prompt_no_outputs"
+	TypePromptOutputsFailed    = "prompt_outputs_failed_validation"
+	TypeInvalidPrompt          = "invalid_prompt"
+	TypeCustomValidationFailed = "custom_validation_failed"
+)
+
+func (e *ValidationError) UnmarshalJSON(p []byte) error {
+	// some errors are plain string, e.g.: {"error": "no prompt"}
+	var msg string
+	if err := json.Unmarshal(p, &msg); err == nil {
+		*e = ValidationError{Message: msg}
+		return nil
+	}
+	type Alias ValidationError
+	return json.Unmarshal(p, (*Alias)(e))
+}
+
+func (e ValidationError) Error() string {
+	var b strings.Builder
+	if e.Type != "" {
+		b.WriteString(e.Type)
+		b.WriteString(": ")
+	}
+	b.WriteString(e.Message)
+	if e.Details != "" {
+		b.WriteString(": ")
+		b.WriteString(e.Details)
+	}
+	return b.String()
+}
+
+type ExtraInfo struct {
+	InputName     string          `json:"input_name,omitempty"`
+	InputConfig   json.RawMessage `json:"input_config,omitempty"`
+	ReceivedValue any             `json:"received_value,omitempty"`
+	// NodeID and ClassType are set on missing_node_type
+	NodeID    string `json:"node_id,omitempty"`
+	ClassType string `json:"class_type,omitempty"`
+}
+
+// NodeError has the validation errors of a node
+type NodeError struct {
+	NodeID           string            `json:"-"`
+	ClassType        string            `json:"class_type"`
+	Errors           []ValidationError `json:"errors"`
+	DependentOutputs []string          `json:"dependent_outputs"`
+}
+
+func (e NodeError) String() string {
+	errs := make([]string, 0, len(e.Errors))
+	for _, v := range e.Errors {
+		errs = append(errs, v.Error())
+	}
+	return fmt.Sprintf("node #%s (%s): %s", e.NodeID, e.ClassType, strings.Join(errs, "; "))
+}
+
+// NodeErrors has node id in keys
+type NodeErrors map[string]NodeError
+
+func (e *NodeErrors) UnmarshalJSON(p []byte) error {
+	// empty list in some error response
+	var list []json.RawMessage
+	if err := json.Unmarshal(p, &list); err == nil {
+		*e = make(NodeErrors)
+		return nil
+	}
+	var m map[string]NodeError
+	if err := json.Unmarshal(p, &m); err != nil {
+		return err
+	}
+	for id, v := range m {
+		v.NodeID = id
+		m[id] = v
+	}
+	*e = m
+	return nil
+}
+
+// Sorted return node errors sorted by node id, numeric ids are compared as numbers
+func (e NodeErrors) Sorted() []NodeError {
+	list := make([]NodeError, 0, len(e))
+	for _, v := range e {
+		list = append(list, v)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return lessNodeID(list[i].NodeID, list[j].NodeID)
+	})
+	return list
+}
+
+// lessNodeID compare a and b as numbers if both are, e.g.: "9" < "10",
+// otherwise as strings, e.g.: the ids of group nodes "5:1" are after the numeric ids
+func lessNodeID(a, b string) bool {
+	x, errA := strconv.Atoi(a)
+	y, errB := strconv.Atoi(b)
+	switch {
+	case errA == nil && errB == nil:
+		return x < y
+	case errA == nil || errB == nil:
+		return errA == nil
+	default:
+		return a < b
+	}
+}
+
+func (e NodeErrors) String() string {
+	list := make([]string, 0, len(e))
+	for _, v := range e.Sorted() {
+		list = append(list, v.String())
+	}
+	return strings.Join(list, "\n")
+}
+
+// PromptError is the response of invalid prompt
+type PromptError struct {
+	Error      ValidationError `json:"error"`
+	NodeErrors NodeErrors      `json:"node_errors"`
+}
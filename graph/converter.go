@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"reflect"
 
+	comfyui "github.com/sko00o/comfyui-go"
 	nd "github.com/sko00o/comfyui-go/node"
 )
 
@@ -289,20 +290,10 @@ func findOriginalSource(nodeRedirectMap map[string]*nd.PreNode, nodeID string) *
 }
 
 // InputDef 定义输入参数的结构: [type, {options}]
-type InputDef []interface{}
+type InputDef = comfyui.InputDef
 
 // NodeInfo 存储节点的输入参数定义
-type NodeInfo struct {
-	Input struct {
-		Required map[string]InputDef `json:"required"`
-		Optional map[string]InputDef `json:"optional"`
-	} `json:"input"`
-	InputOrder struct {
-		Required []string `json:"required"`
-		Optional []string `json:"optional"`
-	} `json:"input_order"`
-	DisplayName string `json:"display_name"`
-}
+type NodeInfo = comfyui.NodeInfo
 
 func processParamSlice(inputs map[string]interface{}, paramName string, paramDef InputDef, widgetsValue reflect.Value, index int) int {
 	widget := widgetsValue.Index(index).Interface()
@@ -1,13 +1,15 @@
 package graph
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"net/http"
 	"os"
 	"path/filepath"
 	"sync"
 	"time"
+
+	comfyui "github.com/sko00o/comfyui-go"
 )
 
 type CachedHTTPObjectInfoFetcher struct {
@@ -23,6 +25,32 @@ func NewCachedHTTPObjectInfoFetcher(baseURL string) *CachedHTTPObjectInfoFetcher
 	}
 }
 
+// NewCachedClientObjectInfoFetcher 复用 client 的 transport、鉴权与超时配置
+func NewCachedClientObjectInfoFetcher(cli *comfyui.Client) *CachedHTTPObjectInfoFetcher {
+	return &CachedHTTPObjectInfoFetcher{
+		HTTPObjectInfoFetcher: *NewClientObjectInfoFetcher(cli),
+		cache:                 make(NodeInfos),
+	}
+}
+
+// FetchAll 一次性获取全部节点定义并存入缓存
+func (f *CachedHTTPObjectInfoFetcher) FetchAll(ctx context.Context) error {
+	if f.err != nil {
+		return f.err
+	}
+	infos, err := f.client.ObjectInfo(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to fetch object info: %w", err)
+	}
+
+	f.cacheMutex.Lock()
+	for nodeType, info := range infos {
+		f.cache[nodeType] = info
+	}
+	f.cacheMutex.Unlock()
+	return nil
+}
+
 func (f *CachedHTTPObjectInfoFetcher) FetchNodeInfo(nodeType string) (*NodeInfo, error) {
 	f.cacheMutex.RLock()
 	if info, exists := f.cache[nodeType]; exists {
@@ -48,46 +76,37 @@ func (f *CachedHTTPObjectInfoFetcher) FetchNodeInfo(nodeType string) (*NodeInfo,
 // HTTPObjectInfoFetcher 实现从 HTTP 接口获取 object_info
 type HTTPObjectInfoFetcher struct {
 	BaseURL string
-	client  *http.Client
+	client  *comfyui.Client
+	err     error
 }
 
 func NewHTTPObjectInfoFetcher(baseURL string) *HTTPObjectInfoFetcher {
+	cli, err := comfyui.New(comfyui.Config{
+		Endpoint: baseURL,
+		Timeout:  10 * time.Second,
+	})
 	return &HTTPObjectInfoFetcher{
 		BaseURL: baseURL,
-		client:  &http.Client{Timeout: 10 * time.Second},
+		client:  cli,
+		err:     err,
 	}
 }
 
-func (f *HTTPObjectInfoFetcher) FetchNodeInfo(nodeType string) (*NodeInfo, error) {
-	url := fmt.Sprintf("%s/api/object_info/%s", f.BaseURL, nodeType)
-	resp, err := f.client.Get(url)
-	if err != nil {
-		return nil, fmt.Errorf("failed to fetch node info for %q: %v", nodeType, err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to fetch node info for %q: status code %d", nodeType, resp.StatusCode)
+// NewClientObjectInfoFetcher 复用 client 的 transport、鉴权与超时配置
+func NewClientObjectInfoFetcher(cli *comfyui.Client) *HTTPObjectInfoFetcher {
+	return &HTTPObjectInfoFetcher{
+		BaseURL: cli.BaseURL.String(),
+		client:  cli,
 	}
+}
 
-	/*
-		resp body is like this:
-		{
-			"LoadImage": {
-				"input": {
-					...
-				}
-			}
-		}
-	*/
-	var nodeInfos NodeInfos
-	if err := json.NewDecoder(resp.Body).Decode(&nodeInfos); err != nil {
-		return nil, fmt.Errorf("failed to decode node info for %q: %v", nodeType, err)
+func (f *HTTPObjectInfoFetcher) FetchNodeInfo(nodeType string) (*NodeInfo, error) {
+	if f.err != nil {
+		return nil, f.err
 	}
-
-	nodeInfo, ok := nodeInfos[nodeType]
-	if !ok {
-		return nil, fmt.Errorf("node info for %q not found", nodeType)
+	nodeInfo, err := f.client.NodeInfo(context.Background(), nodeType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch node info for %q: %w", nodeType, err)
 	}
 	return nodeInfo, nil
 }
@@ -98,7 +117,7 @@ type FileObjectInfoFetcher struct {
 	cache   NodeInfos
 }
 
-type NodeInfos map[string]*NodeInfo
+type NodeInfos = comfyui.NodeInfos
 
 func NewFileObjectInfoFetcher(fileDir string) (*FileObjectInfoFetcher, error) {
 	// read all json files in the directory
@@ -0,0 +1,14 @@
+package helper
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// NewID return a random ID in UUID format
+func NewID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	h := hex.EncodeToString(b[:])
+	return h[:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+}
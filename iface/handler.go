@@ -1,5 +1,10 @@
 package iface
 
+import (
+	"encoding/json"
+	"time"
+)
+
 type Handler interface {
 	HandlePayload(id string, p []byte, progressChan chan<- ProgressInfo) (any, error)
 }
@@ -14,4 +19,34 @@ type ProgressInfo struct {
 	NodeID     string `json:"node_id"`
 	PercentNum int    `json:"percent_num"`
 	Hostname   string `json:"hostname"`
+
+	// ClassType of the node, empty if unknown
+	ClassType string `json:"class_type,omitempty"`
+	// Step and Max are the progress of the node, e.g.: the sampling steps
+	Step int `json:"step,omitempty"`
+	Max  int `json:"max,omitempty"`
+	// ETA is the estimated remaining time of the prompt, zero if unknown,
+	// it is encoded as eta_ms in milliseconds
+	ETA time.Duration `json:"-"`
+}
+
+func (p ProgressInfo) MarshalJSON() ([]byte, error) {
+	type Alias ProgressInfo
+	return json.Marshal(struct {
+		Alias
+		ETAMs int64 `json:"eta_ms,omitempty"`
+	}{Alias(p), p.ETA.Milliseconds()})
+}
+
+func (p *ProgressInfo) UnmarshalJSON(b []byte) error {
+	type Alias ProgressInfo
+	v := struct {
+		*Alias
+		ETAMs int64 `json:"eta_ms,omitempty"`
+	}{Alias: (*Alias)(p)}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	p.ETA = time.Duration(v.ETAMs) * time.Millisecond
+	return nil
 }
@@ -6,3 +6,15 @@ type MessageHandler interface {
 	Close() error
 	Name() string
 }
+
+// ConnStateHandler is optionally implemented by MessageHandler
+// to know the messages may be lost while disconnected
+type ConnStateHandler interface {
+	// OnDisconnect is called when the connection is lost
+	OnDisconnect(err error)
+	// OnReconnect is called when the connection is restored,
+	// the messages in between are lost
+	OnReconnect()
+	// OnGiveUp is called when no more message will be delivered
+	OnGiveUp(err error)
+}
@@ -0,0 +1,39 @@
+package iface
+
+import (
+	"context"
+	"time"
+)
+
+// Metrics receives the measurements of clients and sessions,
+// it must be safe for concurrent use. Embed NopMetrics to implement part of it.
+type Metrics interface {
+	// StartRequest is called before sending an HTTP request of path, e.g.: "/api/history".
+	// done is called when the response header is received, statusCode is 0 on error.
+	// The returned ctx is used by the request, e.g.: carry the span.
+	StartRequest(ctx context.Context, path, method string) (_ context.Context, done func(statusCode int, err error))
+	// WsReconnect is called when ws client is reconnected to endpoint
+	WsReconnect(endpoint string)
+	// NodeExecuted is called when a node finished, classType is empty if unknown
+	NodeExecuted(promptID, nodeID, classType string, d time.Duration)
+	// BytesSaved is called when an output of node is saved
+	BytesSaved(nodeID string, n int64)
+	// OOM is called when a prompt failed with out of memory
+	OOM(promptID string)
+}
+
+var _ Metrics = NopMetrics{}
+
+type NopMetrics struct{}
+
+func (NopMetrics) StartRequest(ctx context.Context, _, _ string) (context.Context, func(int, error)) {
+	return ctx, func(int, error) {}
+}
+
+func (NopMetrics) WsReconnect(string) {}
+
+func (NopMetrics) NodeExecuted(string, string, string, time.Duration) {}
+
+func (NopMetrics) BytesSaved(string, int64) {}
+
+func (NopMetrics) OOM(string) {}
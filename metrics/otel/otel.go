@@ -0,0 +1,109 @@
+// Package otel implements iface.Metrics by OpenTelemetry metrics and traces,
+// each request is traced as a client span.
+package otel
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/sko00o/comfyui-go/iface"
+)
+
+const scopeName = "github.com/sko00o/comfyui-go"
+
+var _ iface.Metrics = (*Metrics)(nil)
+
+type Metrics struct {
+	tracer trace.Tracer
+
+	requestDuration metric.Float64Histogram
+	wsReconnects    metric.Int64Counter
+	nodeDuration    metric.Float64Histogram
+	bytesSaved      metric.Int64Counter
+	ooms            metric.Int64Counter
+}
+
+// New create the instruments from mp and the tracer from tp
+func New(mp metric.MeterProvider, tp trace.TracerProvider) (*Metrics, error) {
+	meter := mp.Meter(scopeName)
+	m := &Metrics{tracer: tp.Tracer(scopeName)}
+
+	var err error
+	if m.requestDuration, err = meter.Float64Histogram("comfyui.request.duration",
+		metric.WithUnit("s"),
+		metric.WithDescription("Latency of ComfyUI API requests until response header."),
+	); err != nil {
+		return nil, err
+	}
+	if m.wsReconnects, err = meter.Int64Counter("comfyui.ws.reconnects",
+		metric.WithDescription("WebSocket reconnects."),
+	); err != nil {
+		return nil, err
+	}
+	if m.nodeDuration, err = meter.Float64Histogram("comfyui.node.duration",
+		metric.WithUnit("s"),
+		metric.WithDescription("Execution time of nodes."),
+	); err != nil {
+		return nil, err
+	}
+	if m.bytesSaved, err = meter.Int64Counter("comfyui.saved",
+		metric.WithUnit("By"),
+		metric.WithDescription("Bytes of outputs saved."),
+	); err != nil {
+		return nil, err
+	}
+	if m.ooms, err = meter.Int64Counter("comfyui.oom",
+		metric.WithDescription("Prompts failed with out of memory."),
+	); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m *Metrics) StartRequest(ctx context.Context, path, method string) (context.Context, func(int, error)) {
+	start := time.Now()
+	attrs := []attribute.KeyValue{
+		attribute.String("http.request.method", method),
+		attribute.String("url.template", path),
+	}
+	ctx, span := m.tracer.Start(ctx, method+" "+path,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...),
+	)
+	return ctx, func(statusCode int, err error) {
+		defer span.End()
+		if err != nil {
+			attrs = append(attrs, attribute.String("error.type", "request"))
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		} else {
+			attrs = append(attrs, attribute.Int("http.response.status_code", statusCode))
+			span.SetAttributes(attribute.Int("http.response.status_code", statusCode))
+			if statusCode >= 400 {
+				span.SetStatus(codes.Error, "")
+			}
+		}
+		m.requestDuration.Record(ctx, time.Since(start).Seconds(), metric.WithAttributes(attrs...))
+	}
+}
+
+func (m *Metrics) WsReconnect(endpoint string) {
+	m.wsReconnects.Add(context.Background(), 1, metric.WithAttributes(attribute.String("server.address", endpoint)))
+}
+
+func (m *Metrics) NodeExecuted(_, _, classType string, d time.Duration) {
+	m.nodeDuration.Record(context.Background(), d.Seconds(), metric.WithAttributes(attribute.String("comfyui.node.class_type", classType)))
+}
+
+func (m *Metrics) BytesSaved(_ string, n int64) {
+	m.bytesSaved.Add(context.Background(), n)
+}
+
+func (m *Metrics) OOM(string) {
+	m.ooms.Add(context.Background(), 1)
+}
@@ -0,0 +1,50 @@
+package otel
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestMetrics(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	spans := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans))
+
+	m, err := New(mp, tp)
+	require.NoError(t, err)
+
+	_, done := m.StartRequest(context.Background(), "/api/prompt", "POST")
+	done(400, nil)
+	m.WsReconnect("localhost:8188")
+	m.NodeExecuted("p1", "3", "KSampler", time.Second)
+	m.BytesSaved("9", 1024)
+	m.OOM("p1")
+
+	ended := spans.Ended()
+	require.Len(t, ended, 1)
+	assert.Equal(t, "POST /api/prompt", ended[0].Name())
+
+	var rm metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(context.Background(), &rm))
+	require.Len(t, rm.ScopeMetrics, 1)
+	names := map[string]bool{}
+	for _, md := range rm.ScopeMetrics[0].Metrics {
+		names[md.Name] = true
+	}
+	assert.Equal(t, map[string]bool{
+		"comfyui.request.duration": true,
+		"comfyui.ws.reconnects":    true,
+		"comfyui.node.duration":    true,
+		"comfyui.saved":            true,
+		"comfyui.oom":              true,
+	}, names)
+}
@@ -0,0 +1,147 @@
+// Package prometheus implements iface.Metrics by Prometheus collectors.
+package prometheus
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/sko00o/comfyui-go/iface"
+)
+
+var _ iface.Metrics = (*Metrics)(nil)
+
+type Metrics struct {
+	requestDuration *prometheus.HistogramVec
+	requests        *prometheus.CounterVec
+	wsReconnects    *prometheus.CounterVec
+	nodeDuration    *prometheus.HistogramVec
+	bytesSaved      prometheus.Counter
+	ooms            prometheus.Counter
+
+	nodeIDs bool
+}
+
+type Option func(o *options)
+
+type options struct {
+	namespace string
+	buckets   []float64
+	nodeIDs   bool
+}
+
+// WithNamespace set the metric namespace, default is "comfyui"
+func WithNamespace(namespace string) Option {
+	return func(o *options) {
+		o.namespace = namespace
+	}
+}
+
+// WithBuckets set the buckets of duration histograms in seconds
+func WithBuckets(buckets []float64) Option {
+	return func(o *options) {
+		o.buckets = buckets
+	}
+}
+
+// WithNodeIDLabel label node metrics by node id besides class type,
+// only use it for a fixed set of workflows
+func WithNodeIDLabel() Option {
+	return func(o *options) {
+		o.nodeIDs = true
+	}
+}
+
+// New create the collectors and register them to reg
+func New(reg prometheus.Registerer, opts ...Option) (*Metrics, error) {
+	o := &options{
+		namespace: "comfyui",
+		buckets:   []float64{.01, .05, .1, .5, 1, 5, 10, 30, 60, 300, 900},
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	nodeLabels := []string{"class_type"}
+	if o.nodeIDs {
+		nodeLabels = append(nodeLabels, "node_id")
+	}
+
+	m := &Metrics{
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: o.namespace,
+			Name:      "request_duration_seconds",
+			Help:      "Latency of ComfyUI API requests until response header.",
+			Buckets:   o.buckets,
+		}, []string{"path", "method"}),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: o.namespace,
+			Name:      "requests_total",
+			Help:      "ComfyUI API requests by status code, code is \"error\" if no response.",
+		}, []string{"path", "method", "code"}),
+		wsReconnects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: o.namespace,
+			Name:      "ws_reconnects_total",
+			Help:      "WebSocket reconnects.",
+		}, []string{"endpoint"}),
+		nodeDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: o.namespace,
+			Name:      "node_execution_seconds",
+			Help:      "Execution time of nodes.",
+			Buckets:   o.buckets,
+		}, nodeLabels),
+		bytesSaved: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: o.namespace,
+			Name:      "saved_bytes_total",
+			Help:      "Bytes of outputs saved.",
+		}),
+		ooms: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: o.namespace,
+			Name:      "oom_total",
+			Help:      "Prompts failed with out of memory.",
+		}),
+
+		nodeIDs: o.nodeIDs,
+	}
+	for _, c := range []prometheus.Collector{
+		m.requestDuration, m.requests, m.wsReconnects, m.nodeDuration, m.bytesSaved, m.ooms,
+	} {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func (m *Metrics) StartRequest(ctx context.Context, path, method string) (context.Context, func(int, error)) {
+	start := time.Now()
+	return ctx, func(statusCode int, err error) {
+		m.requestDuration.WithLabelValues(path, method).Observe(time.Since(start).Seconds())
+		code := "error"
+		if err == nil {
+			code = strconv.Itoa(statusCode)
+		}
+		m.requests.WithLabelValues(path, method, code).Inc()
+	}
+}
+
+func (m *Metrics) WsReconnect(endpoint string) {
+	m.wsReconnects.WithLabelValues(endpoint).Inc()
+}
+
+func (m *Metrics) NodeExecuted(_, nodeID, classType string, d time.Duration) {
+	labels := prometheus.Labels{"class_type": classType}
+	if m.nodeIDs {
+		labels["node_id"] = nodeID
+	}
+	m.nodeDuration.With(labels).Observe(d.Seconds())
+}
+
+func (m *Metrics) BytesSaved(_ string, n int64) {
+	m.bytesSaved.Add(float64(n))
+}
+
+func (m *Metrics) OOM(string) {
+	m.ooms.Inc()
+}
@@ -0,0 +1,46 @@
+package prometheus
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m, err := New(reg)
+	require.NoError(t, err)
+
+	_, done := m.StartRequest(context.Background(), "/api/history", "GET")
+	done(200, nil)
+	_, done = m.StartRequest(context.Background(), "/api/history", "GET")
+	done(0, errors.New("refused"))
+	m.WsReconnect("localhost:8188")
+	m.NodeExecuted("p1", "3", "KSampler", time.Second)
+	m.BytesSaved("9", 1024)
+	m.OOM("p1")
+
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP comfyui_requests_total ComfyUI API requests by status code, code is "error" if no response.
+# TYPE comfyui_requests_total counter
+comfyui_requests_total{code="200",method="GET",path="/api/history"} 1
+comfyui_requests_total{code="error",method="GET",path="/api/history"} 1
+# HELP comfyui_ws_reconnects_total WebSocket reconnects.
+# TYPE comfyui_ws_reconnects_total counter
+comfyui_ws_reconnects_total{endpoint="localhost:8188"} 1
+# HELP comfyui_saved_bytes_total Bytes of outputs saved.
+# TYPE comfyui_saved_bytes_total counter
+comfyui_saved_bytes_total 1024
+# HELP comfyui_oom_total Prompts failed with out of memory.
+# TYPE comfyui_oom_total counter
+comfyui_oom_total 1
+`), "comfyui_requests_total", "comfyui_ws_reconnects_total", "comfyui_saved_bytes_total", "comfyui_oom_total"))
+	assert.Equal(t, 1, testutil.CollectAndCount(m.nodeDuration))
+}
@@ -0,0 +1,90 @@
+package recorder
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// kinds of cassette entry
+const (
+	KindHTTP = "http"
+	KindWS   = "ws"
+)
+
+// Entry is one line of the JSONL cassette,
+// it is either an HTTP exchange or a WebSocket frame from server
+type Entry struct {
+	Kind string `json:"kind"`
+	// Time is when the request is sent or the frame is received,
+	// HTTP entries are written after the response so they may be out of order
+	Time time.Time `json:"time"`
+
+	Request  *Request  `json:"request,omitempty"`
+	Response *Response `json:"response,omitempty"`
+
+	MessageType int    `json:"message_type,omitempty"`
+	Data        []byte `json:"data,omitempty"`
+}
+
+type Request struct {
+	Method string `json:"method"`
+	// URL is the path with query, host is dropped to replay on any address
+	URL    string      `json:"url"`
+	Header http.Header `json:"header,omitempty"`
+	Body   []byte      `json:"body,omitempty"`
+}
+
+// key match the replayed request, the query is normalized
+func (r *Request) key() string {
+	return requestKey(r.Method, r.URL)
+}
+
+func requestKey(method, urlStr string) string {
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return method + " " + urlStr
+	}
+	return method + " " + u.EscapedPath() + "?" + u.Query().Encode()
+}
+
+type Response struct {
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       []byte      `json:"body,omitempty"`
+}
+
+type Cassette struct {
+	Entries []Entry
+}
+
+// Load read the cassette file written by Recorder
+func Load(path string) (*Cassette, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open: %w", err)
+	}
+	defer f.Close()
+	return Read(f)
+}
+
+func Read(rd io.Reader) (*Cassette, error) {
+	var c Cassette
+	dec := json.NewDecoder(rd)
+	for {
+		var e Entry
+		if err := dec.Decode(&e); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, fmt.Errorf("decode entry %d: %w", len(c.Entries), err)
+		}
+		c.Entries = append(c.Entries, e)
+	}
+	return &c, nil
+}
@@ -0,0 +1,189 @@
+package recorder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	comfyui "github.com/sko00o/comfyui-go"
+	"github.com/sko00o/comfyui-go/ws"
+)
+
+// DefaultRedactHeaders are not written to the cassette
+var DefaultRedactHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"Set-Cookie",
+}
+
+// Recorder write HTTP exchanges and WebSocket frames to a JSONL cassette
+type Recorder struct {
+	mu     sync.Mutex
+	enc    *json.Encoder
+	closer io.Closer
+	err    error
+
+	redactHeaders []string
+}
+
+type Option func(r *Recorder)
+
+// WithRedactHeaders replace DefaultRedactHeaders
+func WithRedactHeaders(keys ...string) Option {
+	return func(r *Recorder) {
+		r.redactHeaders = keys
+	}
+}
+
+func New(w io.Writer, opts ...Option) *Recorder {
+	r := &Recorder{
+		enc:           json.NewEncoder(w),
+		redactHeaders: DefaultRedactHeaders,
+	}
+	if c, ok := w.(io.Closer); ok {
+		r.closer = c
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Create record to the file of path, it is truncated if exists
+func Create(path string, opts ...Option) (*Recorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("create: %w", err)
+	}
+	return New(f, opts...), nil
+}
+
+// Err return the first error writing cassette
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closer == nil {
+		return r.err
+	}
+	if err := r.closer.Close(); err != nil && r.err == nil {
+		r.err = err
+	}
+	return r.err
+}
+
+func (r *Recorder) write(e Entry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return
+	}
+	if err := r.enc.Encode(e); err != nil {
+		r.err = fmt.Errorf("encode entry: %w", err)
+	}
+}
+
+func (r *Recorder) redact(h http.Header) http.Header {
+	h = h.Clone()
+	for _, k := range r.redactHeaders {
+		h.Del(k)
+	}
+	if len(h) == 0 {
+		return nil
+	}
+	return h
+}
+
+// ClientOptions return the options to record a comfyui.Client,
+// the HTTP requests are sent by http.DefaultTransport
+func (r *Recorder) ClientOptions() []comfyui.Option {
+	return []comfyui.Option{
+		comfyui.WithTransport(r.Transport(nil)),
+		comfyui.WithWsOptions(ws.WithMiddleware(r.Handler)),
+	}
+}
+
+// Transport record the exchanges sent by next,
+// nil next means http.DefaultTransport
+func (r *Recorder) Transport(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		start := time.Now()
+		reqBody, err := readBody(&req.Body)
+		if err != nil {
+			return nil, fmt.Errorf("read request body: %w", err)
+		}
+		resp, err := next.RoundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+		respBody, err := readBody(&resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("read response body: %w", err)
+		}
+		r.write(Entry{
+			Kind: KindHTTP,
+			Time: start,
+			Request: &Request{
+				Method: req.Method,
+				URL:    req.URL.RequestURI(),
+				Header: r.redact(req.Header),
+				Body:   reqBody,
+			},
+			Response: &Response{
+				StatusCode: resp.StatusCode,
+				Header:     r.redact(resp.Header),
+				Body:       respBody,
+			},
+		})
+		return resp, nil
+	})
+}
+
+// Handler record the frames before passing them to next, next can be nil
+func (r *Recorder) Handler(next ws.Handler) ws.Handler {
+	return ws.HandlerFunc(func(messageType int, message []byte) {
+		r.write(Entry{
+			Kind:        KindWS,
+			Time:        time.Now(),
+			MessageType: messageType,
+			Data:        message,
+		})
+		if next != nil {
+			next.HandleMessage(messageType, message)
+		}
+	})
+}
+
+// readBody read all of body and replace it for the next reader
+func readBody(body *io.ReadCloser) ([]byte, error) {
+	if *body == nil || *body == http.NoBody {
+		return nil, nil
+	}
+	p, err := io.ReadAll(*body)
+	_ = (*body).Close()
+	if err != nil {
+		return nil, err
+	}
+	*body = io.NopCloser(bytes.NewReader(p))
+	return p, nil
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
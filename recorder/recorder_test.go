@@ -0,0 +1,141 @@
+package recorder
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	comfyui "github.com/sko00o/comfyui-go"
+	"github.com/sko00o/comfyui-go/logger"
+	"github.com/sko00o/comfyui-go/ws"
+)
+
+// newUpstream send frames of the prompt after it is submitted
+func newUpstream(t *testing.T) *httptest.Server {
+	submitted := make(chan string, 1)
+	var upgrader websocket.Upgrader
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/prompt", func(w http.ResponseWriter, r *http.Request) {
+		submitted <- "p1"
+		_, _ = w.Write([]byte(`{"prompt_id":"p1","number":1}`))
+	})
+	mux.HandleFunc("GET /api/prompt", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"exec_info":{"queue_remaining":0}}`))
+	})
+	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		require.NoError(t, err)
+		defer conn.Close()
+		_ = conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"status","data":{}}`))
+		id := <-submitted
+		_ = conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"executing","data":{"node":"1","prompt_id":"`+id+`"}}`))
+		_ = conn.WriteMessage(websocket.BinaryMessage, []byte{0, 0, 0, 1, 0, 0, 0, 2, 'p', 'n', 'g'})
+		_, _, _ = conn.ReadMessage()
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+type frame struct {
+	messageType int
+	data        []byte
+}
+
+func runSession(t *testing.T, endpoint string, opts ...comfyui.Option) []frame {
+	cli, err := comfyui.New(comfyui.Config{Endpoint: endpoint}, opts...)
+	require.NoError(t, err)
+
+	frames := make(chan frame, 10)
+	wsOpts, err := cli.WsOptions()
+	require.NoError(t, err)
+	wc, err := ws.New(cli.BaseURL, "c1", ws.HandlerFunc(func(messageType int, p []byte) {
+		frames <- frame{messageType, p}
+	}), logger.NewStd(), wsOpts...)
+	require.NoError(t, err)
+	defer wc.Close()
+
+	var got []frame
+	got = append(got, <-frames)
+
+	q, err := cli.GetPromptContext(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 0, q.ExecInfo.QueueRemaining)
+
+	// no frame of the prompt before submitting
+	select {
+	case f := <-frames:
+		t.Fatalf("unexpected frame %s", f.data)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	resp, err := cli.PromptContext(context.Background(), map[string]any{"1": map[string]any{}})
+	require.NoError(t, err)
+	assert.Equal(t, "p1", resp.PromptID)
+	got = append(got, <-frames, <-frames)
+	return got
+}
+
+func TestRecordReplay(t *testing.T) {
+	upstream := newUpstream(t)
+
+	var buf bytes.Buffer
+	rec := New(&buf)
+	recorded := runSession(t, upstream.URL,
+		append(rec.ClientOptions(), comfyui.WithBearerToken("secret"))...)
+	require.NoError(t, rec.Err())
+	assert.NotContains(t, buf.String(), "secret")
+
+	cassette, err := Read(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	kinds := map[string]int{}
+	for _, e := range cassette.Entries {
+		kinds[e.Kind]++
+	}
+	assert.Equal(t, map[string]int{KindHTTP: 2, KindWS: 3}, kinds)
+
+	srv := NewReplayer(cassette).Server()
+	defer srv.Close()
+	replayed := runSession(t, srv.URL)
+	assert.Equal(t, recorded, replayed)
+}
+
+func TestReplayer_RoundTrip(t *testing.T) {
+	cassette := &Cassette{Entries: []Entry{
+		{Kind: KindHTTP, Request: &Request{Method: http.MethodGet, URL: "/api/history/p1"}, Response: &Response{StatusCode: http.StatusOK, Body: []byte(`{}`)}},
+		{Kind: KindHTTP, Request: &Request{Method: http.MethodGet, URL: "/api/history/p1"}, Response: &Response{StatusCode: http.StatusOK, Body: []byte(`{"p1":{"outputs":{}}}`)}},
+		{Kind: KindHTTP, Request: &Request{Method: http.MethodGet, URL: "/api/view?type=output&filename=a.png"}, Response: &Response{StatusCode: http.StatusOK, Body: []byte(`png`)}},
+	}}
+	cli, err := comfyui.New(comfyui.Config{Endpoint: "http://comfyui.invalid"}, comfyui.WithTransport(NewReplayer(cassette)))
+	require.NoError(t, err)
+
+	_, err = cli.GetHistoryByID("p1")
+	assert.ErrorIs(t, err, comfyui.ErrHistoryNotFound)
+	// the last record is repeated
+	for range 2 {
+		_, err = cli.GetHistoryByID("p1")
+		require.NoError(t, err)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "http://comfyui.invalid/api/view?"+url.Values{
+		"filename": {"a.png"},
+		"type":     {"output"},
+	}.Encode(), nil)
+	require.NoError(t, err)
+	resp, err := cli.Do(req)
+	require.NoError(t, err)
+	var body bytes.Buffer
+	_, _ = body.ReadFrom(resp.Body)
+	assert.Equal(t, "png", body.String())
+
+	_, err = cli.Stats()
+	assert.ErrorIs(t, err, ErrNoRecord)
+}
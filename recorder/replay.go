@@ -0,0 +1,203 @@
+package recorder
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+var ErrNoRecord = errors.New("no record")
+
+// Replayer serve the cassette back.
+//
+// HTTP requests are matched by method, path and query in recorded order,
+// the last match is repeated once all are served, e.g. for polling.
+// WebSocket frames are sent in order to the connected client, each frame
+// waits for the non-GET requests sent before it was received, so the frames
+// of a prompt are not sent before the prompt is submitted.
+type Replayer struct {
+	entries []Entry
+	byKey   map[string][]int
+
+	mu      sync.Mutex
+	served  map[int]bool
+	cursor  map[string]int
+	wsNext  int
+	changed chan struct{}
+
+	upgrader websocket.Upgrader
+}
+
+func NewReplayer(c *Cassette) *Replayer {
+	r := &Replayer{
+		entries: c.Entries,
+		byKey:   make(map[string][]int),
+		served:  make(map[int]bool),
+		cursor:  make(map[string]int),
+		changed: make(chan struct{}),
+	}
+	for i, e := range c.Entries {
+		if e.Kind == KindHTTP && e.Request != nil && e.Response != nil {
+			key := e.Request.key()
+			r.byKey[key] = append(r.byKey[key], i)
+		}
+	}
+	return r
+}
+
+// Server start a server replaying HTTP and WebSocket,
+// use its URL as the endpoint of comfyui.Config
+func (r *Replayer) Server() *httptest.Server {
+	return httptest.NewServer(r)
+}
+
+// RoundTrip replay HTTP only, without a server
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		_ = req.Body.Close()
+	}
+	rec, err := r.match(req)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Response{
+		Status:        strconv.Itoa(rec.StatusCode) + " " + http.StatusText(rec.StatusCode),
+		StatusCode:    rec.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        rec.Header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(rec.Body)),
+		ContentLength: int64(len(rec.Body)),
+		Request:       req,
+	}, nil
+}
+
+func (r *Replayer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if websocket.IsWebSocketUpgrade(req) {
+		r.serveWS(w, req)
+		return
+	}
+	rec, err := r.match(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	for k, vs := range rec.Header {
+		for _, v := range vs {
+			w.Header().Add(k, v)
+		}
+	}
+	w.Header().Del("Content-Length")
+	w.WriteHeader(rec.StatusCode)
+	_, _ = w.Write(rec.Body)
+}
+
+func (r *Replayer) match(req *http.Request) (*Response, error) {
+	key := requestKey(req.Method, req.URL.RequestURI())
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	idx := r.byKey[key]
+	if len(idx) == 0 {
+		return nil, fmt.Errorf("%w: %s %s", ErrNoRecord, req.Method, req.URL.RequestURI())
+	}
+	n := r.cursor[key]
+	if n < len(idx) {
+		r.cursor[key] = n + 1
+	} else {
+		n = len(idx) - 1
+	}
+	if !r.served[idx[n]] {
+		r.served[idx[n]] = true
+		close(r.changed)
+		r.changed = make(chan struct{})
+	}
+	return r.entries[idx[n]].Response, nil
+}
+
+func (r *Replayer) serveWS(w http.ResponseWriter, req *http.Request) {
+	conn, err := r.upgrader.Upgrade(w, req, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(req.Context())
+	defer cancel()
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		i, ok := r.nextFrame()
+		if !ok {
+			break
+		}
+		if err := r.waitReady(ctx, i); err != nil {
+			return
+		}
+		e := r.entries[i]
+		if err := conn.WriteMessage(e.MessageType, e.Data); err != nil {
+			return
+		}
+	}
+	<-ctx.Done()
+	_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+}
+
+// nextFrame take the next frame, a reconnected client continues from it
+func (r *Replayer) nextFrame() (int, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for ; r.wsNext < len(r.entries); r.wsNext++ {
+		if r.entries[r.wsNext].Kind == KindWS {
+			r.wsNext++
+			return r.wsNext - 1, true
+		}
+	}
+	return 0, false
+}
+
+func (r *Replayer) waitReady(ctx context.Context, frame int) error {
+	for {
+		r.mu.Lock()
+		ready := r.readyLocked(frame)
+		changed := r.changed
+		r.mu.Unlock()
+		if ready {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (r *Replayer) readyLocked(frame int) bool {
+	at := r.entries[frame].Time
+	for i, e := range r.entries {
+		if e.Kind != KindHTTP || e.Request == nil || e.Request.Method == http.MethodGet {
+			continue
+		}
+		if e.Time.Before(at) && !r.served[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,150 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"time"
+)
+
+// defaultCancelTimeout is how long a canceled prompt is drained if CancelTimeout is not set
+const defaultCancelTimeout = 30 * time.Second
+
+// CanceledError is returned when the wait of a prompt is canceled,
+// the prompt is interrupted or deleted from queue on server
+type CanceledError struct {
+	PromptID string
+	// Cause is the error of context, e.g.: context.Canceled,
+	// the error also matches ErrTimeout if it is context.DeadlineExceeded
+	Cause error
+	// State is the state of prompt after draining, it is not final if the prompt did not stop in time
+	State PromptState
+	// NodesTime is the execution time of nodes before canceled
+	NodesTime map[string]time.Duration
+	// Files is nodeID -> filenames saved before canceled
+	Files map[string][]string
+}
+
+func (e *CanceledError) Error() string {
+	return fmt.Sprintf("prompt %s canceled (%s): %v", e.PromptID, e.State, e.Cause)
+}
+
+func (e *CanceledError) Unwrap() []error {
+	if errors.Is(e.Cause, context.DeadlineExceeded) {
+		return []error{e.Cause, ErrTimeout}
+	}
+	return []error{e.Cause}
+}
+
+// WaitContext is like Wait, but the prompts not finished when ctx is done are
+// interrupted on server, their results have a *CanceledError
+func (s *Session) WaitContext(ctx context.Context) map[string]SessionResult {
+	return s.wait(ctx.Done(), func(promptID string) error {
+		return s.cancelPrompt(promptID, context.Cause(ctx))
+	})
+}
+
+// Run wait for the tracked prompts by WaitContext, return their errors joined,
+// a *CanceledError can be found by errors.As if ctx is done before they finished
+func (s *Session) Run(ctx context.Context) error {
+	var errs []error
+	resMap := s.WaitContext(ctx)
+	for _, promptID := range s.tracked() {
+		errs = append(errs, resMap[promptID].Errs...)
+	}
+	return errors.Join(errs...)
+}
+
+// cancelPrompt interrupt promptID and drain its messages until it stops,
+// a *CanceledError is returned unless it finished successfully
+func (s *Session) cancelPrompt(promptID string, cause error) error {
+	timeout := s.cancelTimeout()
+	// the requests are made even if the context of session is canceled
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(s.ctx), timeout)
+	defer cancel()
+
+	done := s.doneOf(promptID)
+	if err := s.interruptPrompt(ctx, promptID); err != nil {
+		s.Logger.Warnf("interrupt prompt %s: %v", promptID, err)
+	}
+
+	interval := s.HistoryPollInterval
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+DRAIN:
+	for !s.stopped(ctx, promptID) {
+		select {
+		case <-done:
+			break DRAIN
+		case <-ticker.C:
+			// the messages may be lost, or no message comes for the prompt deleted from queue
+		case <-ctx.Done():
+			s.Logger.Warnf("prompt %s is not stopped in %v", promptID, timeout)
+			break DRAIN
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.promptSnapshotLocked(promptID)
+	if p.State == PromptSucceeded || p.State == PromptCached {
+		// finished before interrupted
+		return nil
+	}
+	return &CanceledError{
+		PromptID:  promptID,
+		Cause:     cause,
+		State:     p.State,
+		NodesTime: maps.Clone(s.NodesTime),
+		Files:     p.Files,
+	}
+}
+
+func (s *Session) cancelTimeout() time.Duration {
+	if s.CancelTimeout <= 0 {
+		return defaultCancelTimeout
+	}
+	return s.CancelTimeout
+}
+
+// interruptPrompt delete promptID from queue if it is pending, and interrupt it if running
+func (s *Session) interruptPrompt(ctx context.Context, promptID string) error {
+	if p, _ := s.PromptState(promptID); p.State == PromptQueued {
+		if err := s.DeleteFromQueueContext(ctx, promptID); err != nil {
+			return fmt.Errorf("delete from queue: %w", err)
+		}
+	}
+	// the state may be out of date, it is a no-op if promptID is not running
+	if err := s.InterruptContext(ctx, promptID); err != nil {
+		return fmt.Errorf("interrupt: %w", err)
+	}
+	return nil
+}
+
+// stopped report whether promptID has stopped on server, it is finished if so
+func (s *Session) stopped(ctx context.Context, promptID string) bool {
+	if p, _ := s.PromptState(promptID); p.State.IsFinal() {
+		return true
+	}
+	if err := s.finishFromHistory(ctx, promptID); err == nil {
+		return true
+	}
+	q, err := s.GetQueueContext(ctx)
+	if err != nil {
+		s.Logger.Warnf("get queue: %v", err)
+		return false
+	}
+	if q.IsRunning(promptID) || q.IsPending(promptID) {
+		return false
+	}
+	// deleted from queue, the history may be written right before leaving the queue
+	if err := s.finishFromHistory(ctx, promptID); err == nil {
+		return true
+	}
+	s.finish(promptID, PromptInterrupted)
+	return true
+}
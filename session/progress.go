@@ -0,0 +1,221 @@
+package session
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/sko00o/comfyui-go/iface"
+)
+
+// defaultNodeTime weights the nodes when no history is known
+const defaultNodeTime = time.Second
+
+// TimeHistory learn the execution time of nodes by class type from sessions,
+// it is safe for concurrent use and can be shared among sessions
+type TimeHistory struct {
+	mu sync.Mutex
+	// classType -> moving average of execution time
+	avg map[string]time.Duration
+}
+
+func NewTimeHistory() *TimeHistory {
+	return &TimeHistory{avg: make(map[string]time.Duration)}
+}
+
+// Observe record that a node of classType took d
+func (h *TimeHistory) Observe(classType string, d time.Duration) {
+	if h == nil || classType == "" {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if avg, ok := h.avg[classType]; ok {
+		// exponential moving average, recent runs count more
+		h.avg[classType] = (avg*7 + d*3) / 10
+		return
+	}
+	h.avg[classType] = d
+}
+
+// Estimate return how long a node of classType takes, false if never observed
+func (h *TimeHistory) Estimate(classType string) (time.Duration, bool) {
+	if h == nil {
+		return 0, false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	d, ok := h.avg[classType]
+	return d, ok
+}
+
+// ClassTypesOf return nodeID -> class_type of prompt, e.g.: the "prompt" of request data
+func ClassTypesOf(prompt any) map[string]string {
+	p, err := json.Marshal(prompt)
+	if err != nil {
+		return nil
+	}
+	var nodes map[string]struct {
+		ClassType string `json:"class_type"`
+	}
+	if err := json.Unmarshal(p, &nodes); err != nil {
+		return nil
+	}
+	classTypes := make(map[string]string, len(nodes))
+	for id, n := range nodes {
+		classTypes[id] = n.ClassType
+	}
+	return classTypes
+}
+
+// LinksOf return nodeID -> the nodes linked to its inputs of prompt, e.g.: the "prompt" of request data
+func LinksOf(prompt any) map[string][]string {
+	p, err := json.Marshal(prompt)
+	if err != nil {
+		return nil
+	}
+	var nodes map[string]struct {
+		Inputs map[string]json.RawMessage `json:"inputs"`
+	}
+	if err := json.Unmarshal(p, &nodes); err != nil {
+		return nil
+	}
+	links := make(map[string][]string, len(nodes))
+	for id, n := range nodes {
+		for _, input := range n.Inputs {
+			// a link is [nodeID, outputIndex]
+			var link []any
+			if err := json.Unmarshal(input, &link); err != nil || len(link) != 2 {
+				continue
+			}
+			if from, ok := link[0].(string); ok {
+				links[id] = append(links[id], from)
+			}
+		}
+	}
+	return links
+}
+
+// stepProgress is the step of the running node
+type stepProgress struct {
+	nodeID     string
+	value, max int
+}
+
+// nodeWeight return the expected execution time of nodeID, known is false if it is a guess
+func (s *Session) nodeWeight(nodeID string) (w time.Duration, known bool) {
+	if d, ok := s.TimeHistory.Estimate(s.NodeClassTypes[nodeID]); ok && d > 0 {
+		return d, true
+	}
+	return defaultNodeTime, false
+}
+
+// expectedNodesLocked return the nodes expected to execute, they are the nodes on the path of
+// trigger nodes if the links are known, otherwise all nodes of prompt, s.mu must be held
+func (s *Session) expectedNodesLocked() map[string]struct{} {
+	if s.expected != nil {
+		return s.expected
+	}
+	s.expected = make(map[string]struct{}, len(s.NodeClassTypes))
+	if len(s.NodeLinks) == 0 || len(s.IsTriggerNode) == 0 {
+		for id := range s.NodeClassTypes {
+			s.expected[id] = struct{}{}
+		}
+		return s.expected
+	}
+	var walk func(id string)
+	walk = func(id string) {
+		if _, ok := s.expected[id]; ok {
+			return
+		}
+		if _, ok := s.NodeClassTypes[id]; !ok {
+			return
+		}
+		s.expected[id] = struct{}{}
+		for _, from := range s.NodeLinks[id] {
+			walk(from)
+		}
+	}
+	for id := range s.IsTriggerNode {
+		walk(id)
+	}
+	return s.expected
+}
+
+// progressInfo weight each node by its expected time, the running node counts by its steps,
+// the cached nodes and the nodes never execute weigh nothing.
+// The ETA is zero if neither the history nor the nodes of prompt are known, s.mu must be held.
+func (s *Session) progressInfo(nodeID string, running bool) iface.ProgressInfo {
+	info := iface.ProgressInfo{
+		NodeID:    nodeID,
+		ClassType: s.NodeClassTypes[nodeID],
+	}
+
+	var done, remaining time.Duration
+	anyKnown := false
+	executed := make(map[string]struct{}, len(s.ExecutedNodes))
+	for _, id := range s.ExecutedNodes {
+		if _, ok := executed[id]; ok {
+			continue
+		}
+		executed[id] = struct{}{}
+		if _, ok := s.cachedNodes[id]; ok {
+			continue
+		}
+		if running && id == nodeID {
+			continue
+		}
+		w, _ := s.nodeWeight(id)
+		done += w
+	}
+	total := done
+
+	if running {
+		w, known := s.nodeWeight(nodeID)
+		anyKnown = known
+		frac := 0.0
+		if st := s.step; st != nil && st.nodeID == nodeID && st.max > 0 {
+			info.Step, info.Max = st.value, st.max
+			frac = min(float64(st.value)/float64(st.max), 1)
+		}
+		done += time.Duration(float64(w) * frac)
+		total += w
+		elapsed := time.Since(s.lastNodeStartTime)
+		if frac > 0 {
+			// the rate of steps is better than history
+			remaining += time.Duration(float64(elapsed) / frac * (1 - frac))
+			anyKnown = true
+		} else {
+			remaining += max(w-elapsed, 0)
+		}
+	}
+
+	for id := range s.expectedNodesLocked() {
+		if _, ok := executed[id]; ok {
+			continue
+		}
+		w, known := s.nodeWeight(id)
+		anyKnown = anyKnown || known
+		remaining += w
+		total += w
+	}
+	if len(s.NodeClassTypes) == 0 {
+		// count the nodes by TotalNodes
+		if s.TotalNodes > len(executed) {
+			total += time.Duration(s.TotalNodes-len(executed)) * defaultNodeTime
+		}
+		anyKnown = false
+	}
+
+	if total > 0 {
+		info.PercentNum = int(float64(done) / float64(total) * 100)
+	}
+	// progress will no larger than 99
+	if info.PercentNum >= 100 {
+		info.PercentNum = 99
+	}
+	if anyKnown {
+		info.ETA = remaining
+	}
+	return info
+}
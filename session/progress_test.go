@@ -0,0 +1,169 @@
+package session
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sko00o/comfyui-go/iface"
+)
+
+func TestTimeHistory(t *testing.T) {
+	h := NewTimeHistory()
+	_, ok := h.Estimate("KSampler")
+	assert.False(t, ok)
+
+	h.Observe("KSampler", 10*time.Second)
+	h.Observe("KSampler", 20*time.Second)
+	d, ok := h.Estimate("KSampler")
+	assert.True(t, ok)
+	assert.Equal(t, 13*time.Second, d)
+
+	var nilHistory *TimeHistory
+	nilHistory.Observe("KSampler", time.Second)
+	_, ok = nilHistory.Estimate("KSampler")
+	assert.False(t, ok)
+}
+
+func TestClassTypesOf(t *testing.T) {
+	got := ClassTypesOf(map[string]any{
+		"3": map[string]any{"class_type": "KSampler", "inputs": map[string]any{}},
+		"9": map[string]any{"class_type": "SaveImage"},
+	})
+	assert.Equal(t, map[string]string{"3": "KSampler", "9": "SaveImage"}, got)
+}
+
+func TestLinksOf(t *testing.T) {
+	got := LinksOf(map[string]any{
+		"1": map[string]any{"class_type": "Loader", "inputs": map[string]any{"ckpt_name": "a.safetensors"}},
+		"3": map[string]any{"class_type": "KSampler", "inputs": map[string]any{"model": []any{"1", 0}, "steps": 20}},
+		"9": map[string]any{"class_type": "SaveImage", "inputs": map[string]any{"images": []any{"3", 0}}},
+	})
+	assert.Equal(t, map[string][]string{"3": {"1"}, "9": {"3"}}, got)
+}
+
+func TestSession_progressInfo(t *testing.T) {
+	history := NewTimeHistory()
+	history.Observe("Loader", time.Second)
+	history.Observe("KSampler", 8*time.Second)
+	history.Observe("SaveImage", time.Second)
+
+	t.Run("weighted steps", func(t *testing.T) {
+		s := &Session{
+			NodeClassTypes: map[string]string{"1": "Loader", "3": "KSampler", "9": "SaveImage"},
+			TimeHistory:    history,
+			ExecutedNodes:  []string{"1", "3"},
+			step:           &stepProgress{nodeID: "3", value: 4, max: 8},
+		}
+		s.lastNodeStartTime = time.Now().Add(-2 * time.Second)
+
+		info := s.progressInfo("3", true)
+		assert.Equal(t, 50, info.PercentNum)
+		assert.Equal(t, "KSampler", info.ClassType)
+		assert.Equal(t, 4, info.Step)
+		assert.Equal(t, 8, info.Max)
+		// 2s for the other half of steps, 1s for SaveImage
+		assert.InDelta(t, float64(3*time.Second), float64(info.ETA), float64(100*time.Millisecond))
+	})
+
+	t.Run("cached", func(t *testing.T) {
+		s := &Session{
+			NodeClassTypes: map[string]string{"1": "Loader", "3": "KSampler", "9": "SaveImage"},
+			TimeHistory:    history,
+			ExecutedNodes:  []string{"1", "3"},
+		}
+		info := s.progressInfo("3", false)
+		assert.Equal(t, 90, info.PercentNum)
+		assert.Equal(t, time.Second, info.ETA)
+	})
+
+	t.Run("execution cached", func(t *testing.T) {
+		s := &Session{
+			NodeClassTypes: map[string]string{"1": "Loader", "3": "KSampler", "9": "SaveImage"},
+			TimeHistory:    history,
+			ExecutedNodes:  []string{"1", "3"},
+			cachedNodes:    map[string]struct{}{"1": {}},
+			step:           &stepProgress{nodeID: "3", value: 4, max: 8},
+		}
+		s.lastNodeStartTime = time.Now()
+		// 4s of 9s, the cached Loader weighs nothing
+		info := s.progressInfo("3", true)
+		assert.Equal(t, 44, info.PercentNum)
+	})
+
+	t.Run("not on output path", func(t *testing.T) {
+		s := &Session{
+			IsTriggerNode:  map[string]string{"9": ""},
+			NodeClassTypes: map[string]string{"1": "Loader", "3": "KSampler", "5": "Loader", "9": "SaveImage"},
+			NodeLinks:      map[string][]string{"3": {"1"}, "9": {"3"}},
+			TimeHistory:    history,
+			ExecutedNodes:  []string{"1", "3"},
+			step:           &stepProgress{nodeID: "3", value: 4, max: 8},
+		}
+		s.lastNodeStartTime = time.Now()
+		// the dangling Loader never executes
+		info := s.progressInfo("3", true)
+		assert.Equal(t, 50, info.PercentNum)
+	})
+
+	t.Run("unknown nodes", func(t *testing.T) {
+		s := &Session{
+			TotalNodes:    4,
+			ExecutedNodes: []string{"1", "2"},
+		}
+		s.lastNodeStartTime = time.Now()
+		info := s.progressInfo("2", true)
+		assert.Equal(t, 25, info.PercentNum)
+		assert.Zero(t, info.ETA)
+	})
+}
+
+func TestSession_progressInfoLocked(t *testing.T) {
+	history := NewTimeHistory()
+	history.Observe("Loader", time.Second)
+	history.Observe("KSampler", 8*time.Second)
+	history.Observe("SaveImage", time.Second)
+	s := &Session{
+		NodeClassTypes: map[string]string{"1": "Loader", "3": "KSampler", "9": "SaveImage"},
+		TimeHistory:    history,
+		ProgressChan:   make(chan iface.ProgressInfo),
+		ExecutedNodes:  []string{"1", "3"},
+		step:           &stepProgress{nodeID: "3", value: 4, max: 8},
+	}
+	s.lastNodeStartTime = time.Now()
+
+	info := s.progressInfoLocked("3", true)
+	require.NotNil(t, info)
+	assert.Equal(t, 50, info.PercentNum)
+
+	// only the changes of percent are sent
+	s.step = &stepProgress{nodeID: "3", value: 4, max: 8}
+	assert.Nil(t, s.progressInfoLocked("3", true))
+	s.step = &stepProgress{nodeID: "3", value: 5, max: 8}
+	info = s.progressInfoLocked("3", true)
+	require.NotNil(t, info)
+	assert.Equal(t, 60, info.PercentNum)
+
+	// the step changes with the same percent
+	s.step = &stepProgress{nodeID: "3", value: 50, max: 100}
+	require.NotNil(t, s.progressInfoLocked("3", true))
+	s.step = &stepProgress{nodeID: "3", value: 51, max: 100}
+	info = s.progressInfoLocked("3", true)
+	require.NotNil(t, info)
+	assert.Equal(t, 50, info.PercentNum)
+	assert.Equal(t, 51, info.Step)
+}
+
+func TestProgressInfo_JSON(t *testing.T) {
+	info := iface.ProgressInfo{NodeID: "3", PercentNum: 50, ETA: 1500 * time.Millisecond}
+	p, err := json.Marshal(info)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"node_id":"3","percent_num":50,"hostname":"","eta_ms":1500}`, string(p))
+
+	var got iface.ProgressInfo
+	require.NoError(t, json.Unmarshal(p, &got))
+	assert.Equal(t, info, got)
+}
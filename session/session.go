@@ -2,6 +2,7 @@ package session
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -9,6 +10,7 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
 	"sync"
 	"text/template"
 	"time"
@@ -52,17 +54,18 @@ type Session struct {
 	*comfyui.Client
 	Handler SaveHandler
 
+	// ctx cancels the requests made by session, e.g.: output downloads
+	ctx context.Context
+
 	// nodeID -> outputDir
 	IsTriggerNode map[string]string
-	runningNode   *SaveSession
 
 	// nodeID -> filenames
 	NameMapCh map[string]chan string
 	// nodeID -> texts
 	TextMapCh map[string]chan string
 
-	idx    atomic.Uint32
-	resMap sync.Map
+	idx atomic.Uint32
 
 	FilenameTmpl *template.Template
 	ClientID     string
@@ -71,17 +74,59 @@ type Session struct {
 
 	Logger logger.Logger
 
-	TotalNodes    int
+	TotalNodes int
+	// ExecutedNodes are the nodes executed or cached, updated under the lock of session,
+	// use Snapshot to read it while the session is running
 	ExecutedNodes []string
 	ProgressChan  chan<- iface.ProgressInfo
+	// NodeClassTypes is nodeID -> class_type of the prompt, see ClassTypesOf,
+	// it weights the progress and names the nodes in progress and metrics
+	NodeClassTypes map[string]string
+	// NodeLinks is nodeID -> the nodes linked to its inputs, see LinksOf,
+	// the nodes not on the path of IsTriggerNode are left out of the progress
+	NodeLinks map[string][]string
+	// TimeHistory weights the progress of nodes by their historical execution time
+	TimeHistory *TimeHistory
 
 	RetryTimes int
 
-	done chan struct{}
-
+	done      chan struct{}
+	closeOnce sync.Once
+
+	// HistoryPollInterval is the interval of polling history after ws gave up
+	HistoryPollInterval time.Duration
+	// CancelTimeout is how long a canceled prompt is drained until it stops, default 30s
+	CancelTimeout time.Duration
+	// missed is set when ws messages may be lost
+	missed atomic.Bool
+	// lost is closed when ws gave up, no more message will be handled
+	lost     chan struct{}
+	lostOnce sync.Once
+
+	// mu protects the state below, it is changed by the ws goroutine
+	// and read by Wait and snapshots, no I/O is done while holding it
+	mu sync.Mutex
+	// promptID -> track, order is the promptIDs by Track
+	prompts map[string]*promptTrack
+	order   []string
+	// promptID/nodeID of handled outputs
+	// handledOutputs is closed when the output is saved
+	handledOutputs map[string]chan struct{}
+
+	runningNode       *SaveSession
+	step              *stepProgress
 	lastNodeID        string
 	lastNodeStartTime time.Time
-	NodesTime         map[string]time.Duration
+	// NodesTime is nodeID -> execution time, updated under the lock of session,
+	// use Snapshot to read it while the session is running
+	NodesTime map[string]time.Duration
+	// cachedNodes are the nodes cached, they weigh nothing in the progress
+	cachedNodes map[string]struct{}
+	// expected is the nodes expected to execute, see expectedNodesLocked
+	expected map[string]struct{}
+	// sent is the last progress sent, valid if progressSent
+	sent         iface.ProgressInfo
+	progressSent bool
 }
 
 func New(taskID, clientID, promptID string,
@@ -102,6 +147,8 @@ func New(taskID, clientID, promptID string,
 		Client:  client,
 		Handler: handler,
 
+		ctx: context.Background(),
+
 		IsTriggerNode: isTriggerNodeID,
 
 		ClientID:     clientID,
@@ -112,99 +159,246 @@ func New(taskID, clientID, promptID string,
 		TextMapCh:    textMapCh, // each id has text chan
 		Logger:       logger,
 
-		TotalNodes:    totalNodes,
-		ExecutedNodes: make([]string, 0, totalNodes),
-		ProgressChan:  progressChan,
+		TotalNodes:   totalNodes,
+		ProgressChan: progressChan,
 
 		RetryTimes: retryTimes,
 
 		done: make(chan struct{}),
+		lost: make(chan struct{}),
+
+		prompts:        make(map[string]*promptTrack),
+		handledOutputs: make(map[string]chan struct{}),
+
+		ExecutedNodes: make([]string, 0, totalNodes),
+		NodesTime:     make(map[string]time.Duration),
+	}
+}
 
-		NodesTime: make(map[string]time.Duration),
+// SetContext set the context for requests made by session,
+// it should be called before any message is handled
+func (s *Session) SetContext(ctx context.Context) {
+	s.ctx = ctx
+}
+
+// requestContext return the context for requests made while handling messages,
+// the requests draining a canceled prompt are still made in CancelTimeout
+func (s *Session) requestContext() (context.Context, context.CancelFunc) {
+	if s.ctx.Err() == nil {
+		return s.ctx, func() {}
+	}
+	return context.WithTimeout(context.WithoutCancel(s.ctx), s.cancelTimeout())
+}
+
+// metrics return the metrics of client
+func (s *Session) metrics() iface.Metrics {
+	if s.Client == nil {
+		return iface.NopMetrics{}
 	}
+	return s.Client.Metrics()
 }
 
 type RespResult struct {
-	QPResp    comfyui.QueuePromptResp
+	QPResp comfyui.QueuePromptResp
+	// ErrorChan receives the errors of prompt then closed when the prompt finished.
+	//
+	// Deprecated: use Wait or PromptState for the errors
 	ErrorChan chan error
 }
 
+// Close is called by both Wait and the ws client
 func (s *Session) Close() error {
-	if s.done != nil {
-		close(s.done)
-	}
+	s.closeOnce.Do(func() {
+		if s.done != nil {
+			close(s.done)
+		}
+	})
 	return nil
 }
 
+// Track wait for the prompt of resp in Wait, its messages arrived before are kept
+func (s *Session) Track(resp comfyui.QueuePromptResp) {
+	s.StoreResp(resp.PromptID, RespResult{QPResp: resp})
+}
+
+// StoreResp is like Track, the ErrorChan of resp receives the errors when the prompt finished
 func (s *Session) StoreResp(promptID string, resp RespResult) {
-	s.resMap.Store(promptID, resp)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.promptLocked(promptID)
+	if p.submitted {
+		return
+	}
+	p.submitted = true
+	p.resp = resp.QPResp
+	p.errCh = resp.ErrorChan
+	if p.errCh != nil && p.state.IsFinal() {
+		sendErrs(p.errCh, slices.Clone(p.errs))
+	}
+	s.order = append(s.order, promptID)
 }
 
 func (s *Session) LoadResp(promptID string) (RespResult, bool) {
-	v, ok := s.resMap.Load(promptID)
-	if !ok {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.prompts[promptID]
+	if !ok || !p.submitted {
 		return RespResult{}, false
 	}
-	return v.(RespResult), true
+	return RespResult{QPResp: p.resp, ErrorChan: p.errCh}, true
 }
 
 func (s *Session) RangeResp(fn func(promptID string, resp RespResult) bool) {
-	s.resMap.Range(func(k, v any) bool {
-		return fn(k.(string), v.(RespResult))
-	})
+	for _, promptID := range s.tracked() {
+		resp, _ := s.LoadResp(promptID)
+		if !fn(promptID, resp) {
+			return
+		}
+	}
+}
+
+// tracked return the promptIDs by Track
+func (s *Session) tracked() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.order)
+}
+
+// doneOf return the channel closed when promptID finished
+func (s *Session) doneOf(promptID string) <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.promptLocked(promptID).done
 }
 
 type SessionResult struct {
 	QPResp comfyui.QueuePromptResp
+	State  PromptState
 	Errs   []error
+	// Files is nodeID -> filenames saved
+	Files map[string][]string
 }
 
+// Wait for the tracked prompts, the prompts not finished in maxTimeout keep running on server
+// and their results have ErrTimeout, use WaitContext to interrupt them
 func (s *Session) Wait(maxTimeout time.Duration) map[string]SessionResult {
+	// expired is closed instead of a timer channel, so all prompts see the timeout
+	expired := make(chan struct{})
+	timer := time.AfterFunc(maxTimeout, func() { close(expired) })
+	defer timer.Stop()
+	return s.wait(expired, s.recoverFromHistory)
+}
+
+// wait for the tracked prompts, onExpired is called for the prompts not finished when expired is closed
+func (s *Session) wait(expired <-chan struct{}, onExpired func(promptID string) error) map[string]SessionResult {
 	defer s.Close()
 	resMap := make(map[string]SessionResult)
 
-	timeout := time.NewTimer(maxTimeout)
-	defer timeout.Stop()
-
-	s.RangeResp(func(promptID string, resp RespResult) bool {
-		var errs []error
-		errCh := resp.ErrorChan
-
-	SLOOP:
-		for {
+	for _, promptID := range s.tracked() {
+		var err error
+		done := s.doneOf(promptID)
+		select {
+		case <-done:
+		case <-expired:
 			select {
-			case err, ok := <-errCh:
-				if !ok {
-					break SLOOP
-				}
-				errs = append(errs, err)
-			case <-timeout.C:
-				errs = append(errs, ErrTimeout)
-				break SLOOP
+			case <-done:
+				// finished right before expired
+			default:
+				err = onExpired(promptID)
 			}
+		case <-s.lost:
+			err = s.pollHistory(promptID, expired, onExpired)
 		}
 
+		p, _ := s.PromptState(promptID)
+		if err != nil {
+			p.Errs = append(p.Errs, err)
+		}
 		resMap[promptID] = SessionResult{
-			QPResp: resp.QPResp,
-			Errs:   errs,
+			QPResp: p.QPResp,
+			State:  p.State,
+			Errs:   p.Errs,
+			Files:  p.Files,
 		}
-		return true
-	})
+	}
 	return resMap
 }
 
-func (s *Session) handleResult(promptID string, err error, isFinal bool) {
-	resMap, ok := s.LoadResp(promptID)
-	if ok {
-		resCh := resMap.ErrorChan
-		if err != nil {
-			resCh <- err
+// recoverFromHistory finish promptID from its history,
+// return ErrTimeout if the prompt is not finished
+func (s *Session) recoverFromHistory(promptID string) error {
+	if err := s.finishFromHistory(s.ctx, promptID); err != nil {
+		if !errors.Is(err, comfyui.ErrHistoryNotFound) {
+			s.Logger.Warnf("recover from history: %v", err)
+		}
+		return ErrTimeout
+	}
+	return nil
+}
+
+// pollHistory wait for promptID by polling its history after ws gave up
+func (s *Session) pollHistory(promptID string, expired <-chan struct{}, onExpired func(promptID string) error) error {
+	s.Logger.Warnf("ws gave up, poll history of prompt %s", promptID)
+	interval := s.HistoryPollInterval
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	done := s.doneOf(promptID)
+	for {
+		select {
+		case <-done:
+			return nil
+		case <-ticker.C:
+			if err := s.finishFromHistory(s.ctx, promptID); err != nil {
+				if !errors.Is(err, comfyui.ErrHistoryNotFound) {
+					s.Logger.Warnf("poll history: %v", err)
+				}
+				continue
+			}
+			return nil
+		case <-expired:
+			return onExpired(promptID)
 		}
-		if isFinal {
-			s.runningNode = nil
-			close(resCh)
+	}
+}
+
+// finishFromHistory save the outputs not handled yet and finish promptID by its history,
+// err is not nil if the history is not available, e.g.: the prompt is not finished
+func (s *Session) finishFromHistory(ctx context.Context, promptID string) error {
+	obj, err := s.GetHistoryByIDContext(ctx, promptID)
+	if err != nil {
+		return err
+	}
+	s.Logger.Infof("recover from history, status: %q", obj.Status.StatusStr)
+
+	report := func(err error) {
+		s.addErr(promptID, err)
+	}
+	for nodeID, output := range obj.Outputs {
+		var mapOutput message.MapOutput
+		if err := json.Unmarshal(output, &mapOutput); err != nil {
+			report(fmt.Errorf("unmarshal output of node #%s: %w", nodeID, err))
+			continue
 		}
+		s.handleOutput(ctx, promptID, nodeID, mapOutput, report)
 	}
+
+	if !obj.Status.IsError() {
+		s.finish(promptID, PromptSucceeded)
+		return nil
+	}
+	state := PromptFailed
+	if obj.Status.IsInterrupted() {
+		state = PromptInterrupted
+	}
+	msg, _ := json.Marshal(obj.Status)
+	s.finish(promptID, state, comfyError.ComfyUIError{
+		Message:   json.RawMessage(msg),
+		NodesTime: s.nodesTimeCopy(),
+	})
+	return nil
 }
 
 func (s *Session) handleTextMessage(msg []byte) {
@@ -219,80 +413,116 @@ func (s *Session) handleTextMessage(msg []byte) {
 	}
 
 	switch m.Type {
+	case message.ExecutionStart:
+		s.mu.Lock()
+		s.setRunningLocked(m.Data.GetPromptID(), false)
+		s.mu.Unlock()
 	case message.Executing:
 		if o, ok := m.Data.(*message.DataExecuting); ok {
-			if s.lastNodeID != "" {
-				s.NodesTime[s.lastNodeID] += time.Since(s.lastNodeStartTime)
-			}
-			s.lastNodeID = ""
-			s.lastNodeStartTime = time.Now()
-
-			if o.Node != nil {
-				s.lastNodeID = *o.Node
-				s.updateProgress(o.GetPromptID(), *o.Node)
-			} else {
-				// final state
-				s.handleResult(m.Data.GetPromptID(), nil, true)
-			}
+			s.handleExecuting(o.GetPromptID(), o.Node)
 		}
-	case message.ExecutionSuccess:
-		s.handleResult(m.Data.GetPromptID(), nil, false)
 	case message.ExecutionError:
 		// check if the error is OOM
 		isOOM := false
 		if o, ok := m.Data.(*message.DataExecutionError); ok {
 			isOOM = o.IsOOM()
 		}
-		err := comfyError.ComfyUIError{
+		if isOOM {
+			s.metrics().OOM(m.Data.GetPromptID())
+		}
+		s.finish(m.Data.GetPromptID(), PromptFailed, comfyError.ComfyUIError{
 			Message:   json.RawMessage(msg),
 			IsOOM:     isOOM,
-			NodesTime: s.NodesTime,
-		}
-		s.handleResult(m.Data.GetPromptID(), err, false)
+			NodesTime: s.nodesTimeCopy(),
+		})
 	case message.ExecutionInterrupted:
-		err := comfyError.ComfyUIError{
+		s.finish(m.Data.GetPromptID(), PromptInterrupted, comfyError.ComfyUIError{
 			Message:   json.RawMessage(msg),
-			NodesTime: s.NodesTime,
-		}
-		s.handleResult(m.Data.GetPromptID(), err, false)
+			NodesTime: s.nodesTimeCopy(),
+		})
 	case message.Executed:
 		if o, ok := m.Data.(*message.DataExecuted); ok {
 			if o.Node != nil {
-				dir, ok := s.IsTriggerNode[*o.Node]
-				if ok {
-					if content, ok := o.Output["text"]; ok {
-						s.handleText(*o.Node, content)
-					}
-					if dir != "" {
-						for _, name := range SupportedOutputKeys {
-							if content, ok := o.Output[name]; ok {
-								newContent, err := s.modifyFileInfo(*o.Node, o.PromptID, content)
-								if err != nil {
-									s.Logger.Errorf("handle fileinfo: %v", err)
-								} else {
-									o.Output[name] = newContent
-								}
-							}
-						}
-						m.Data = o
-					}
-				}
+				ctx, cancel := s.requestContext()
+				s.handleOutput(ctx, o.PromptID, *o.Node, o.Output, func(err error) {
+					s.addErr(o.PromptID, err)
+				})
+				cancel()
 			}
 		}
 	case message.ExecutionCached:
 		if o, ok := m.Data.(*message.DataExecution); ok {
+			s.mu.Lock()
+			s.setRunningLocked(o.GetPromptID(), false)
+			var info *iface.ProgressInfo
 			if len(o.Nodes) > 0 {
+				s.promptLocked(o.GetPromptID()).cached = true
+				if s.cachedNodes == nil {
+					s.cachedNodes = make(map[string]struct{}, len(o.Nodes))
+				}
 				for _, nodeID := range o.Nodes {
 					s.NodesTime[nodeID] = time.Duration(0)
+					s.cachedNodes[nodeID] = struct{}{}
 				}
-
-				s.updateProgress(o.GetPromptID(), o.Nodes...)
+				info = s.updateProgressLocked(o.GetPromptID(), false, o.Nodes...)
 			}
+			s.mu.Unlock()
+			s.sendProgress(info)
 		}
 
 	case message.Progress:
-		// if o, ok := m.Data.(*message.DataProgress); ok {}
+		if o, ok := m.Data.(*message.DataProgress); ok && o.Node != nil {
+			s.mu.Lock()
+			var info *iface.ProgressInfo
+			if *o.Node == s.lastNodeID {
+				s.step = &stepProgress{nodeID: *o.Node, value: o.Value, max: o.Max}
+				info = s.progressInfoLocked(*o.Node, true)
+			}
+			s.mu.Unlock()
+			s.sendProgress(info)
+		}
+	}
+}
+
+// handleExecuting account the time of last node, node is nil when promptID finished
+func (s *Session) handleExecuting(promptID string, node *string) {
+	s.mu.Lock()
+	lastNodeID, d := s.lastNodeID, time.Since(s.lastNodeStartTime)
+	if lastNodeID != "" {
+		s.NodesTime[lastNodeID] += d
+	}
+	classType := s.NodeClassTypes[lastNodeID]
+	s.lastNodeID = ""
+	s.lastNodeStartTime = time.Now()
+	s.step = nil
+
+	var info *iface.ProgressInfo
+	if node != nil {
+		s.lastNodeID = *node
+		s.setRunningLocked(promptID, true)
+		info = s.updateProgressLocked(promptID, true, *node)
+	}
+	s.mu.Unlock()
+
+	if lastNodeID != "" {
+		s.TimeHistory.Observe(classType, d)
+		s.metrics().NodeExecuted(promptID, lastNodeID, classType, d)
+	}
+	if node != nil {
+		s.sendProgress(info)
+		return
 	}
+
+	// final state
+	if s.missed.Load() {
+		// the outputs may be missed while disconnected
+		ctx, cancel := s.requestContext()
+		if err := s.finishFromHistory(ctx, promptID); err != nil {
+			s.Logger.Warnf("sync history: %v", err)
+		}
+		cancel()
+	}
+	s.finish(promptID, s.succeededState(promptID))
 }
 
 func (s *Session) handleText(nodeID string, content json.RawMessage) {
@@ -306,7 +536,48 @@ func (s *Session) handleText(nodeID string, content json.RawMessage) {
 	}
 }
 
-func (s *Session) modifyFileInfo(nodeID, promptID string, content json.RawMessage) (json.RawMessage, error) {
+// handleOutput save the output of trigger node, errors are sent to report
+func (s *Session) handleOutput(ctx context.Context, promptID, nodeID string, output message.MapOutput, report func(error)) {
+	dir, ok := s.IsTriggerNode[nodeID]
+	if !ok {
+		return
+	}
+	// the output may be handled by both ws and history,
+	// the later one waits so the prompt is not finished before the output is saved
+	s.mu.Lock()
+	saved, handled := s.handledOutputs[promptID+"/"+nodeID]
+	if !handled {
+		saved = make(chan struct{})
+		s.handledOutputs[promptID+"/"+nodeID] = saved
+	}
+	s.mu.Unlock()
+	if handled {
+		select {
+		case <-saved:
+		case <-ctx.Done():
+		}
+		return
+	}
+	defer close(saved)
+
+	if content, ok := output["text"]; ok {
+		s.handleText(nodeID, content)
+	}
+	if dir != "" {
+		for _, name := range SupportedOutputKeys {
+			if content, ok := output[name]; ok {
+				newContent, err := s.modifyFileInfo(ctx, nodeID, promptID, content, report)
+				if err != nil {
+					s.Logger.Errorf("handle fileinfo: %v", err)
+				} else {
+					output[name] = newContent
+				}
+			}
+		}
+	}
+}
+
+func (s *Session) modifyFileInfo(ctx context.Context, nodeID, promptID string, content json.RawMessage, report func(error)) (json.RawMessage, error) {
 	var files []message.FileInfo
 	if err := json.Unmarshal(content, &files); err != nil {
 		return nil, fmt.Errorf("unmarshal images: %w", err)
@@ -322,7 +593,7 @@ func (s *Session) modifyFileInfo(nodeID, promptID string, content json.RawMessag
 		}
 
 		var realFilename string
-		if err := s.GetView(fi, func(reader io.Reader, header http.Header) error {
+		if err := s.GetViewContext(ctx, fi, func(reader io.Reader, header http.Header) error {
 			ni.ContentType = header.Get("Content-Type")
 			name, saveErr := s.save(nodeID, ni, reader)
 			if saveErr != nil {
@@ -331,7 +602,7 @@ func (s *Session) modifyFileInfo(nodeID, promptID string, content json.RawMessag
 			realFilename = name
 			return nil
 		}); err != nil {
-			s.handleResult(promptID, fmt.Errorf("get image: %w", err), false)
+			report(fmt.Errorf("get image: %w", err))
 			continue
 		}
 
@@ -349,64 +620,104 @@ func (s *Session) modifyFileInfo(nodeID, promptID string, content json.RawMessag
 }
 
 func (s *Session) handleBinaryMessage(msg []byte) {
-	var nodeID, promptID string
-	{
-		ss := s.runningNode
-		if ss == nil {
+	var b message.BinaryMessage
+	if err := b.UnmarshalBinary(msg); err != nil {
+		if errors.Is(err, message.ErrUnknownBinaryType) {
+			s.Logger.Debugf("BIN message: %v, skip", err)
 			return
 		}
-		nodeID = ss.NodeID
-		promptID = ss.ID
+		s.Logger.Warnf("BIN message unmarshal: %v, skip", err)
+		// the output of trigger node is lost
+		if ss, ok := s.running(); ok && s.IsTriggerNode[ss.NodeID] != "" {
+			s.addErr(ss.ID, fmt.Errorf("unmarshal binary: %w", err))
+		}
+		return
+	}
+
+	var (
+		nodeID, promptID string
+		imageType        message.ImageType
+		blob             []byte
+	)
+	switch o := b.Data.(type) {
+	case *message.DataImage:
+		ss, ok := s.running()
+		if !ok {
+			return
+		}
+		nodeID, promptID = ss.NodeID, ss.ID
+		imageType, blob = o.Type, o.Blob
+	case *message.DataImageWithMetadata:
+		// the preview tells its node, it may not be the running one
+		nodeID, promptID = o.Metadata.NodeID, o.Metadata.PromptID
+		imageType, blob = o.Type(), o.Blob
+	case *message.DataText:
+		s.Logger.Debugf("node #%s text: %s", o.NodeID, o.Text)
+		return
+	default:
+		return
 	}
 
 	dir, ok := s.IsTriggerNode[nodeID]
 	if !ok || dir == "" {
 		return
 	}
-
-	var b message.BinaryMessage
-	if err := b.UnmarshalBinary(msg); err != nil {
-		s.Logger.Warnf("BIN message unmarshal: %v, skip", err)
-		s.handleResult(promptID, fmt.Errorf("unmarshal binary: %w", err), false)
+	s.Logger.Debugf("ws trigger save on node #%s", nodeID)
+	ni := NameInfo{
+		ClientID:    s.ClientID,
+		PromptID:    promptID,
+		Index:       s.idx.Add(1),
+		EXT:         imageType.Ext(),
+		TaskID:      s.TaskID,
+		ContentType: imageType.ContentType(),
+	}
+	if _, err := s.save(nodeID, ni, bytes.NewReader(blob)); err != nil {
+		s.addErr(promptID, fmt.Errorf("save: %w", err))
 		return
 	}
+}
 
-	if b.Type == message.PreviewImage {
-		if img, ok := b.Data.(*message.DataImage); ok {
-			s.Logger.Debugf("ws trigger save on node #%s", nodeID)
-			ni := NameInfo{
-				ClientID:    s.ClientID,
-				PromptID:    promptID,
-				Index:       s.idx.Add(1),
-				EXT:         img.Type.Ext(),
-				TaskID:      s.TaskID,
-				ContentType: img.Type.ContentType(),
-			}
-			if _, err := s.save(nodeID, ni, bytes.NewReader(img.Blob)); err != nil {
-				s.handleResult(promptID, fmt.Errorf("save: %w", err), false)
-				return
-			}
-		}
+// running return a copy of the running node
+func (s *Session) running() (SaveSession, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.runningNode == nil {
+		return SaveSession{}, false
 	}
+	return *s.runningNode, true
 }
 
-func (s *Session) updateProgress(promptID string, nodes ...string) {
+// updateProgressLocked add the nodes started or cached, running tells the last one is running,
+// the progress is returned to send after unlocking
+func (s *Session) updateProgressLocked(promptID string, running bool, nodes ...string) *iface.ProgressInfo {
 	s.ExecutedNodes = append(s.ExecutedNodes, nodes...)
 	currentNodeID := nodes[len(nodes)-1]
 	s.runningNode = &SaveSession{
 		ID:     promptID,
 		NodeID: currentNodeID,
 	}
-	if s.ProgressChan != nil {
-		progress := int(float64(len(s.ExecutedNodes)) / float64(s.TotalNodes) * 100)
-		// progress will no larger than 99
-		if progress >= 100 {
-			progress = 99
-		}
-		s.ProgressChan <- iface.ProgressInfo{
-			NodeID:     currentNodeID,
-			PercentNum: progress,
-		}
+	return s.progressInfoLocked(currentNodeID, running)
+}
+
+// progressInfoLocked return nil if no one receives the progress or only the ETA is changed,
+// e.g.: the same step is reported again
+func (s *Session) progressInfoLocked(nodeID string, running bool) *iface.ProgressInfo {
+	if s.ProgressChan == nil {
+		return nil
+	}
+	info := s.progressInfo(nodeID, running)
+	cmp := info
+	cmp.ETA = s.sent.ETA
+	if s.progressSent && cmp == s.sent {
+		return nil
+	}
+	s.progressSent, s.sent = true, info
+	return &info
+}
+
+func (s *Session) sendProgress(info *iface.ProgressInfo) {
+	if info != nil {
+		s.ProgressChan <- *info
 	}
 }
 
@@ -438,7 +749,11 @@ func (s *Session) save(id string, ni NameInfo, rd io.Reader) (string, error) {
 		return "", fmt.Errorf("filename template: %w", err)
 	}
 	s.Logger.Infof("trigger save on node #%s, Content-Type: %q", id, ni.ContentType)
-	return name, s.saveAndProcess(id, name, rd, ni.ContentType)
+	if err := s.saveAndProcess(id, name, rd, ni.ContentType); err != nil {
+		return name, err
+	}
+	s.addFile(ni.PromptID, id, name)
+	return name, nil
 }
 
 func (s *Session) saveAndProcess(id, name string, rd io.Reader, contentType string) error {
@@ -453,7 +768,8 @@ func (s *Session) saveAndProcess(id, name string, rd io.Reader, contentType stri
 	}()
 
 	// Copy input stream to temp file
-	if _, err := io.Copy(tmpFile, rd); err != nil {
+	n, err := io.Copy(tmpFile, rd)
+	if err != nil {
 		return fmt.Errorf("copy to temp file: %w", err)
 	}
 	s.Logger.Debugf("save %s to tmp file %s", name, tmpFile.Name())
@@ -474,6 +790,7 @@ func (s *Session) saveAndProcess(id, name string, rd io.Reader, contentType stri
 			continue
 		}
 		s.Logger.Debugf("save %s success", name)
+		s.metrics().BytesSaved(id, n)
 		return nil
 	}
 	return fmt.Errorf("save: %s, retry %d times, failed", name, retryTimes)
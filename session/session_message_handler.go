@@ -10,7 +10,10 @@ import (
 	"github.com/sko00o/comfyui-go/iface"
 )
 
-var _ iface.MessageHandler = (*WrapSession)(nil)
+var (
+	_ iface.MessageHandler   = (*WrapSession)(nil)
+	_ iface.ConnStateHandler = (*WrapSession)(nil)
+)
 
 type WrapSession struct {
 	*Session
@@ -38,3 +41,30 @@ func (s *WrapSession) ReadMessage() (messageType int, p []byte, err error) {
 func (s *WrapSession) Name() string {
 	return fmt.Sprintf("comfy:%s", s.TaskID)
 }
+
+// OnDisconnect mark the messages may be missed until reconnected
+func (s *WrapSession) OnDisconnect(err error) {
+	s.Logger.Warnf("ws disconnected, messages may be missed: %v", err)
+	s.missed.Store(true)
+}
+
+// OnReconnect finish the prompts completed while disconnected from their history
+func (s *WrapSession) OnReconnect() {
+	for _, promptID := range s.tracked() {
+		if p, _ := s.PromptState(promptID); p.State.IsFinal() {
+			continue
+		}
+		// not finished yet if failed, the final message is still on the way
+		ctx, cancel := s.requestContext()
+		_ = s.finishFromHistory(ctx, promptID)
+		cancel()
+	}
+}
+
+// OnGiveUp let Wait fall back to history polling
+func (s *WrapSession) OnGiveUp(err error) {
+	s.Logger.Errorf("ws gave up, fall back to history polling: %v", err)
+	s.lostOnce.Do(func() {
+		close(s.lost)
+	})
+}
@@ -0,0 +1,221 @@
+package session
+
+import (
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/sko00o/comfyui-go"
+)
+
+type PromptState string
+
+const (
+	PromptQueued  PromptState = "queued"
+	PromptRunning PromptState = "running"
+	// PromptCached finished without executing any node, all outputs are cached
+	PromptCached      PromptState = "cached"
+	PromptSucceeded   PromptState = "succeeded"
+	PromptFailed      PromptState = "failed"
+	PromptInterrupted PromptState = "interrupted"
+)
+
+// IsFinal report whether no more message of the prompt will come
+func (s PromptState) IsFinal() bool {
+	switch s {
+	case PromptCached, PromptSucceeded, PromptFailed, PromptInterrupted:
+		return true
+	}
+	return false
+}
+
+// promptTrack is the state of a prompt, protected by Session.mu
+type promptTrack struct {
+	// submitted is set by Track, the messages may come before it
+	submitted bool
+	resp      comfyui.QueuePromptResp
+	// errCh receives the errors then closed when finished, for the deprecated RespResult.ErrorChan
+	errCh chan error
+
+	state PromptState
+	// started counts the nodes executed, not cached
+	started int
+	// cached is set by execution_cached with any node
+	cached bool
+	errs   []error
+	// nodeID -> filenames saved
+	files map[string][]string
+	// done is closed when the state is final
+	done chan struct{}
+}
+
+func newPromptTrack() *promptTrack {
+	return &promptTrack{
+		state: PromptQueued,
+		done:  make(chan struct{}),
+	}
+}
+
+// PromptSnapshot is the state of a prompt at some time
+type PromptSnapshot struct {
+	QPResp comfyui.QueuePromptResp
+	State  PromptState
+	Errs   []error
+	// Files is nodeID -> filenames saved
+	Files map[string][]string
+}
+
+// Snapshot is the state of a session at some time, it is not changed by the session
+type Snapshot struct {
+	// promptID -> state, only the prompts tracked
+	Prompts map[string]PromptSnapshot
+	// RunningNode is the node executing now, empty if none
+	RunningNode   string
+	ExecutedNodes []string
+	NodesTime     map[string]time.Duration
+}
+
+// Snapshot return a copy of the state, it is safe to call from any goroutine
+func (s *Session) Snapshot() Snapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snap := Snapshot{
+		Prompts:       make(map[string]PromptSnapshot, len(s.order)),
+		ExecutedNodes: slices.Clone(s.ExecutedNodes),
+		NodesTime:     maps.Clone(s.NodesTime),
+	}
+	if s.runningNode != nil {
+		snap.RunningNode = s.runningNode.NodeID
+	}
+	for _, promptID := range s.order {
+		snap.Prompts[promptID] = s.promptSnapshotLocked(promptID)
+	}
+	return snap
+}
+
+// PromptState return the state of promptID, false if it is not tracked
+func (s *Session) PromptState(promptID string) (PromptSnapshot, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.prompts[promptID]
+	if !ok || !p.submitted {
+		return PromptSnapshot{}, false
+	}
+	return s.promptSnapshotLocked(promptID), true
+}
+
+func (s *Session) promptSnapshotLocked(promptID string) PromptSnapshot {
+	p := s.prompts[promptID]
+	return PromptSnapshot{
+		QPResp: p.resp,
+		State:  p.state,
+		Errs:   slices.Clone(p.errs),
+		Files:  cloneFiles(p.files),
+	}
+}
+
+func cloneFiles(files map[string][]string) map[string][]string {
+	if files == nil {
+		return nil
+	}
+	c := make(map[string][]string, len(files))
+	for nodeID, names := range files {
+		c[nodeID] = slices.Clone(names)
+	}
+	return c
+}
+
+// nodesTimeCopy return a copy of NodesTime for the results
+func (s *Session) nodesTimeCopy() map[string]time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return maps.Clone(s.NodesTime)
+}
+
+// promptLocked return the track of promptID, it is created for the message before Track
+func (s *Session) promptLocked(promptID string) *promptTrack {
+	p, ok := s.prompts[promptID]
+	if !ok {
+		p = newPromptTrack()
+		s.prompts[promptID] = p
+	}
+	return p
+}
+
+// setRunningLocked mark promptID running, started tells a node is executed
+func (s *Session) setRunningLocked(promptID string, started bool) {
+	p := s.promptLocked(promptID)
+	if p.state.IsFinal() {
+		return
+	}
+	p.state = PromptRunning
+	if started {
+		p.started++
+	}
+}
+
+// addErr record err of promptID, it is dropped if the prompt has finished
+func (s *Session) addErr(promptID string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.promptLocked(promptID)
+	if p.state.IsFinal() {
+		s.Logger.Debugf("prompt %s has finished, drop error: %v", promptID, err)
+		return
+	}
+	p.errs = append(p.errs, err)
+}
+
+// addFile record name saved for nodeID of promptID
+func (s *Session) addFile(promptID, nodeID, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.promptLocked(promptID)
+	if p.files == nil {
+		p.files = make(map[string][]string)
+	}
+	p.files[nodeID] = append(p.files[nodeID], name)
+}
+
+// succeededState return PromptCached if the nodes are all cached, PromptSucceeded otherwise,
+// it is only known from the messages, not the history
+func (s *Session) succeededState(promptID string) PromptState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.promptLocked(promptID)
+	// the executing messages may be missed while disconnected
+	if p.started == 0 && p.cached && !s.missed.Load() {
+		return PromptCached
+	}
+	return PromptSucceeded
+}
+
+// finish set the final state of promptID with errs, only the first call takes effect
+func (s *Session) finish(promptID string, state PromptState, errs ...error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.promptLocked(promptID)
+	if p.state.IsFinal() {
+		return
+	}
+	p.state = state
+	p.errs = append(p.errs, errs...)
+	if s.runningNode != nil && s.runningNode.ID == promptID {
+		s.runningNode = nil
+	}
+	close(p.done)
+	if p.errCh != nil {
+		sendErrs(p.errCh, slices.Clone(p.errs))
+	}
+}
+
+// sendErrs send errs to the deprecated RespResult.ErrorChan then close it,
+// in a goroutine for the caller may not read it
+func sendErrs(errCh chan<- error, errs []error) {
+	go func() {
+		for _, err := range errs {
+			errCh <- err
+		}
+		close(errCh)
+	}()
+}
@@ -22,6 +22,10 @@ type Supervisor struct {
 	VRAMFreeThreshold float64
 	// TorchVRAMFreeThreshold is the threshold of free torch VRAM usage
 	TorchVRAMFreeThreshold float64
+
+	// FreeWaitTimeout is the max time to wait for memory released by /free
+	// before falling back to reboot, zero means reboot directly
+	FreeWaitTimeout time.Duration
 }
 
 type Option func(d *Supervisor)
@@ -50,6 +54,12 @@ func WithTorchVRAMFreeThreshold(threshold float64) Option {
 	}
 }
 
+func WithFreeWaitTimeout(timeout time.Duration) Option {
+	return func(d *Supervisor) {
+		d.FreeWaitTimeout = timeout
+	}
+}
+
 func NewSupervisor(client *comfyui.Client, opts ...Option) *Supervisor {
 	s := &Supervisor{
 		Client: client,
@@ -58,6 +68,8 @@ func NewSupervisor(client *comfyui.Client, opts ...Option) *Supervisor {
 		RAMFreeThreshold:       0.1,
 		VRAMFreeThreshold:      0.2,
 		TorchVRAMFreeThreshold: 0.1,
+
+		FreeWaitTimeout: time.Second * 10,
 	}
 	for _, opt := range opts {
 		opt(s)
@@ -66,16 +78,55 @@ func NewSupervisor(client *comfyui.Client, opts ...Option) *Supervisor {
 }
 
 func (d *Supervisor) KeepSystemHealthy(ctx context.Context) error {
-	if d.IsQueueEmpty() && d.IsSystemHealthy() {
+	if !d.IsQueueEmpty() {
+		return d.WaitingForReboot(ctx)
+	}
+	if d.IsSystemHealthy() {
+		return nil
+	}
+	if d.WaitingForFree(ctx) {
 		return nil
 	}
 
 	return d.WaitingForReboot(ctx)
 }
 
+// WaitingForFree asks ComfyUI to unload models and free memory,
+// then reports whether the system becomes healthy in FreeWaitTimeout
+func (d *Supervisor) WaitingForFree(ctx context.Context) bool {
+	if d.FreeWaitTimeout <= 0 {
+		return false
+	}
+
+	d.Logger.Infof("system start free memory...")
+	if err := d.FreeContext(ctx, true, true); err != nil {
+		d.Logger.Warnf("free memory: %v", err)
+		return false
+	}
+
+	timeout := time.NewTimer(d.FreeWaitTimeout)
+	defer timeout.Stop()
+	for i := 0; ; i++ {
+		select {
+		case <-ctx.Done():
+			d.Logger.Warnf("waiting for free memory canceled")
+			return false
+		case <-timeout.C:
+			d.Logger.Warnf("waiting for free memory timeout")
+			return false
+		case <-time.After(time.Second):
+		}
+		if d.IsSystemHealthy() {
+			d.Logger.Infof("system is healthy after free memory")
+			return true
+		}
+		d.Logger.Infof("waiting for free memory... %d", i)
+	}
+}
+
 func (d *Supervisor) WaitingForReboot(ctx context.Context) error {
 	d.Logger.Infof("system start reboot...")
-	_ = d.Reboot() // ignore any response
+	_ = d.RebootContext(ctx) // ignore any response
 	return d.WaitingForSystemAlive(ctx)
 }
 
@@ -0,0 +1,98 @@
+package ws
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrHandlerOverflow is reported by OnDisconnect when the handler falls behind
+// and the messages are dropped, the connection is still alive
+var ErrHandlerOverflow = errors.New("ws handler overflow, messages dropped")
+
+// dispatchQueueSize is the max messages waiting for the handler
+const dispatchQueueSize = 1024
+
+// dispatcher run the handler and callbacks in order on its own goroutine,
+// so a slow handler never holds the reading and the keepalive of connection.
+// The messages are dropped when dispatchQueueSize ones are waiting,
+// the callbacks are always queued.
+type dispatcher struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	queue  []func()
+	closed bool
+	done   chan struct{}
+	// busy is set while a func is running
+	busy atomic.Bool
+	// dropped counts the funcs dropped for the queue is full
+	dropped atomic.Int64
+}
+
+func newDispatcher() *dispatcher {
+	d := &dispatcher{done: make(chan struct{})}
+	d.cond = sync.NewCond(&d.mu)
+	go d.run()
+	return d
+}
+
+// push fn to run after the ones pushed before, false if the queue is full
+func (d *dispatcher) push(fn func()) bool {
+	return d.enqueue(fn, false)
+}
+
+// pushAlways is like push ignoring the queue size, for the callbacks
+func (d *dispatcher) pushAlways(fn func()) {
+	d.enqueue(fn, true)
+}
+
+func (d *dispatcher) enqueue(fn func(), always bool) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return true
+	}
+	if !always && len(d.queue) >= dispatchQueueSize {
+		d.dropped.Add(1)
+		return false
+	}
+	d.queue = append(d.queue, fn)
+	d.cond.Signal()
+	return true
+}
+
+// running report whether a func is running, e.g.: the caller may be the func
+func (d *dispatcher) running() bool {
+	return d.busy.Load()
+}
+
+// close wait for the queued funcs to finish
+func (d *dispatcher) close() {
+	d.mu.Lock()
+	d.closed = true
+	d.cond.Signal()
+	d.mu.Unlock()
+	<-d.done
+}
+
+func (d *dispatcher) run() {
+	defer close(d.done)
+	for {
+		d.mu.Lock()
+		for len(d.queue) == 0 && !d.closed {
+			d.cond.Wait()
+		}
+		if len(d.queue) == 0 {
+			d.mu.Unlock()
+			return
+		}
+		fn := d.queue[0]
+		d.queue[0] = nil
+		d.queue = d.queue[1:]
+		d.mu.Unlock()
+
+		d.busy.Store(true)
+		fn()
+		d.busy.Store(false)
+	}
+}
@@ -0,0 +1,92 @@
+package ws
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// KeepaliveConfig detect the dead connection, e.g.: half-open TCP behind a load balancer
+type KeepaliveConfig struct {
+	// PingInterval is the interval of sending pings, default is 9/10 of PongWait
+	PingInterval time.Duration `mapstructure:"ping_interval"`
+	// PongWait is how long the connection is alive without any pong or message, default is 60s
+	PongWait time.Duration `mapstructure:"pong_wait"`
+	// WriteWait is the deadline of each write, default is 10s
+	WriteWait time.Duration `mapstructure:"write_wait"`
+	// Disable pings and read deadlines
+	Disable bool `mapstructure:"disable"`
+}
+
+func (p KeepaliveConfig) withDefault() KeepaliveConfig {
+	if p.PongWait <= 0 {
+		p.PongWait = 60 * time.Second
+	}
+	if p.PingInterval <= 0 || p.PingInterval >= p.PongWait {
+		p.PingInterval = p.PongWait * 9 / 10
+	}
+	if p.WriteWait <= 0 {
+		p.WriteWait = 10 * time.Second
+	}
+	return p
+}
+
+func WithKeepalive(p KeepaliveConfig) Option {
+	return func(c *Client) {
+		c.keepalive = p.withDefault()
+	}
+}
+
+// startKeepalive extend the read deadline of conn on each pong and message,
+// and ping it until stop is closed, conn is closed if the ping can not be sent
+func (c *Client) startKeepalive(conn *websocket.Conn, stop <-chan struct{}) error {
+	if c.keepalive.Disable {
+		return nil
+	}
+	if err := c.extendDeadline(conn); err != nil {
+		return err
+	}
+	conn.SetPongHandler(func(string) error {
+		return c.extendDeadline(conn)
+	})
+
+	go func() {
+		ticker := time.NewTicker(c.keepalive.PingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(c.keepalive.WriteWait)); err != nil {
+					if !errors.Is(err, net.ErrClosed) && !errors.Is(err, websocket.ErrCloseSent) {
+						c.log.Warnf("ws ping: %v", err)
+					}
+					// unblock the reading
+					_ = conn.Close()
+					return
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func (c *Client) extendDeadline(conn *websocket.Conn) error {
+	if c.keepalive.Disable {
+		return nil
+	}
+	return conn.SetReadDeadline(time.Now().Add(c.keepalive.PongWait))
+}
+
+// readErr explain the timeout of read deadline
+func (c *Client) readErr(err error) error {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return fmt.Errorf("no pong in %v: %w", c.keepalive.PongWait, err)
+	}
+	return err
+}
@@ -0,0 +1,32 @@
+package message
+
+// Event is a decoded ws message
+type Event struct {
+	// Type is empty for binary message
+	Type Type
+	// Data is the data of text message, e.g.: *DataExecuting, *DataProgress,
+	// it is nil for binary message and unknown type
+	Data Data
+	// Binary is the decoded binary message, its Data is nil for unknown type
+	Binary *BinaryMessage
+	// PromptID of the message, binary message belongs to the executing prompt
+	PromptID string
+	// Raw is the frame received
+	Raw []byte
+	// Dropped is the total number of events dropped by the stream when this one is queued,
+	// the oldest events are dropped when the consumer falls behind
+	Dropped int
+}
+
+// IsFinal report whether no more event of PromptID will come,
+// i.e.: the prompt is completed, failed or interrupted
+func (e Event) IsFinal() bool {
+	switch e.Type {
+	case ExecutionError, ExecutionInterrupted:
+		return true
+	case Executing:
+		o, ok := e.Data.(*DataExecuting)
+		return ok && o.Node == nil
+	}
+	return false
+}
@@ -3,7 +3,9 @@ package message
 import (
 	"encoding/json"
 	"fmt"
+	"sort"
 	"strings"
+	"sync"
 )
 
 // ref: https://docs.comfy.org/development/comfyui-server/comms_messages
@@ -20,6 +22,8 @@ const (
 	ExecutionCached      Type = "execution_cached"
 	ExecutionSuccess     Type = "execution_success"
 	ExecutionInterrupted Type = "execution_interrupted"
+	// ProgressState is the state of all nodes of a prompt, sent by newer ComfyUI
+	ProgressState Type = "progress_state"
 )
 
 type Data interface {
@@ -30,6 +34,29 @@ type Data interface {
 type Message struct {
 	Type Type `json:"type"`
 	Data Data `json:"data"`
+	// RawData is the undecoded data, it is kept for all types
+	RawData json.RawMessage `json:"-"`
+}
+
+var dataTypes sync.Map
+
+func init() {
+	RegisterType(Status, func() Data { return &DataStatus{} })
+	RegisterType(Executing, func() Data { return &DataExecuting{} })
+	RegisterType(Progress, func() Data { return &DataProgress{} })
+	RegisterType(Executed, func() Data { return &DataExecuted{} })
+	RegisterType(ExecutionStart, func() Data { return &DataExecution{} })
+	RegisterType(ExecutionSuccess, func() Data { return &DataExecution{} })
+	RegisterType(ExecutionCached, func() Data { return &DataExecution{} })
+	RegisterType(ExecutionError, func() Data { return &DataExecutionError{} })
+	RegisterType(ExecutionInterrupted, func() Data { return &DataExecutionInterrupted{} })
+	RegisterType(ProgressState, func() Data { return &DataProgressState{} })
+}
+
+// RegisterType decode the data of t by the Data from newData,
+// e.g.: "crystools.monitor" of extensions, the built-in types can be replaced
+func RegisterType(t Type, newData func() Data) {
+	dataTypes.Store(t, newData)
 }
 
 func (m *Message) UnmarshalJSON(b []byte) error {
@@ -43,24 +70,11 @@ func (m *Message) UnmarshalJSON(b []byte) error {
 	if err := json.Unmarshal(b, a); err != nil {
 		return fmt.Errorf("unmarshal message: %w", err)
 	}
+	m.RawData = a.Data
 
-	switch m.Type {
-	case Status:
-		m.Data = &DataStatus{}
-	case Executing:
-		m.Data = &DataExecuting{}
-	case Progress:
-		m.Data = &DataProgress{}
-	case Executed:
-		m.Data = &DataExecuted{}
-	case ExecutionStart, ExecutionSuccess, ExecutionCached:
-		m.Data = &DataExecution{}
-	case ExecutionError:
-		m.Data = &DataExecutionError{}
-	case ExecutionInterrupted:
-		m.Data = &DataExecutionInterrupted{}
-	default:
-		m.Data = nil // ignore the unknown message data
+	m.Data = nil // ignore the unknown message data
+	if newData, ok := dataTypes.Load(m.Type); ok {
+		m.Data = newData.(func() Data)()
 	}
 
 	if m.Data != nil {
@@ -80,6 +94,14 @@ type DataStatus struct {
 	} `json:"status"`
 }
 
+// GetSID return the client ID of the connection, it is only sent on connect
+func (b DataStatus) GetSID() string {
+	if b.SID == nil {
+		return ""
+	}
+	return *b.SID
+}
+
 func (b DataStatus) GetPromptID() string {
 	return ""
 }
@@ -104,6 +126,14 @@ type DataExecuting struct {
 	DisplayNode *string `json:"display_node,omitempty"`
 }
 
+// GetDisplayNode return the node shown in UI, it differs from Node in subgraphs
+func (d DataExecuting) GetDisplayNode() *string {
+	if d.DisplayNode != nil {
+		return d.DisplayNode
+	}
+	return d.Node
+}
+
 type DataProgress struct {
 	DataExecuting
 	Value int `json:"value"`
@@ -188,6 +218,9 @@ func (f *FileInfo) UnmarshalJSON(data []byte) error {
 }
 
 func (f FileInfo) MarshalJSON() ([]byte, error) {
+	if f.Raw == nil {
+		f.Raw = make(map[string]interface{})
+	}
 	f.Raw["filename"] = f.Filename
 	f.Raw["subfolder"] = f.Subfolder
 	f.Raw["type"] = f.Type
@@ -202,9 +235,10 @@ type DataExecution struct {
 
 type DataExecutionInterrupted struct {
 	ExecutionBase
-	NodeID   string   `json:"node_id"`
-	NodeType string   `json:"node_type"`
-	Executed []string `json:"executed"`
+	NodeID    string   `json:"node_id"`
+	NodeType  string   `json:"node_type"`
+	Executed  []string `json:"executed"`
+	Timestamp *int64   `json:"timestamp,omitempty"`
 }
 
 const (
@@ -232,3 +266,45 @@ func (d *DataExecutionError) IsOOM() bool {
 	}
 	return false
 }
+
+type NodeState string
+
+const (
+	NodeStatePending  NodeState = "pending"
+	NodeStateRunning  NodeState = "running"
+	NodeStateFinished NodeState = "finished"
+	NodeStateError    NodeState = "error"
+)
+
+// NodeProgress is the progress of a node in progress_state
+type NodeProgress struct {
+	NodeID   string    `json:"node_id"`
+	PromptID string    `json:"prompt_id"`
+	Value    float64   `json:"value"`
+	Max      float64   `json:"max"`
+	State    NodeState `json:"state"`
+	// DisplayNodeID, ParentNodeID and RealNodeID locate the node in subgraphs
+	DisplayNodeID string `json:"display_node_id,omitempty"`
+	ParentNodeID  string `json:"parent_node_id,omitempty"`
+	RealNodeID    string `json:"real_node_id,omitempty"`
+}
+
+type DataProgressState struct {
+	ExecutionBase
+	// nodeID -> progress, only the started nodes are present
+	Nodes map[string]NodeProgress `json:"nodes"`
+}
+
+// Running return the nodes running now in ID order
+func (d *DataProgressState) Running() []NodeProgress {
+	var nodes []NodeProgress
+	for _, n := range d.Nodes {
+		if n.State == NodeStateRunning {
+			nodes = append(nodes, n)
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].NodeID < nodes[j].NodeID
+	})
+	return nodes
+}
@@ -3,7 +3,11 @@ package message
 import (
 	"encoding"
 	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
+	"sync"
 )
 
 type EventType uint32
@@ -11,6 +15,9 @@ type EventType uint32
 const (
 	PreviewImage EventType = 1 + iota
 	UnencodedPreviewImage
+	// Text is the progress text of a node
+	Text
+	PreviewImageWithMetadata
 )
 
 type ImageType uint32
@@ -42,23 +49,39 @@ func (t ImageType) ContentType() string {
 	}
 }
 
+var ErrUnknownBinaryType = errors.New("unknown binary type")
+
 type BinaryMessage struct {
 	Type EventType
 	Data encoding.BinaryUnmarshaler
 }
 
+var binaryTypes sync.Map
+
+func init() {
+	RegisterBinaryType(PreviewImage, func() encoding.BinaryUnmarshaler { return &DataImage{} })
+	RegisterBinaryType(UnencodedPreviewImage, func() encoding.BinaryUnmarshaler { return &DataImage{} })
+	RegisterBinaryType(Text, func() encoding.BinaryUnmarshaler { return &DataText{} })
+	RegisterBinaryType(PreviewImageWithMetadata, func() encoding.BinaryUnmarshaler { return &DataImageWithMetadata{} })
+}
+
+// RegisterBinaryType decode the binary message of t by the data from newData,
+// e.g.: the custom event of extensions, the built-in types can be replaced
+func RegisterBinaryType(t EventType, newData func() encoding.BinaryUnmarshaler) {
+	binaryTypes.Store(t, newData)
+}
+
 func (m *BinaryMessage) UnmarshalBinary(message []byte) error {
 	if len(message) < 4 {
 		return fmt.Errorf("length too short")
 	}
 
 	m.Type = EventType(binary.BigEndian.Uint32(message[:4]))
-	switch m.Type {
-	case PreviewImage:
-		m.Data = &DataImage{}
-	default:
-		return fmt.Errorf("unknown type %v", m.Type)
+	newData, ok := binaryTypes.Load(m.Type)
+	if !ok {
+		return fmt.Errorf("%w %v", ErrUnknownBinaryType, m.Type)
 	}
+	m.Data = newData.(func() encoding.BinaryUnmarshaler)()
 
 	buffer := message[4:]
 	return m.Data.UnmarshalBinary(buffer)
@@ -84,3 +107,65 @@ func (m *DataImage) UnmarshalBinary(buffer []byte) error {
 	m.Blob = buffer[4:]
 	return nil
 }
+
+// DataText is the text sent by node, e.g.: the progress text
+type DataText struct {
+	NodeID string
+	Text   string
+}
+
+func (m *DataText) UnmarshalBinary(buffer []byte) error {
+	if len(buffer) < 4 {
+		return fmt.Errorf("text data length too short")
+	}
+	n := binary.BigEndian.Uint32(buffer[:4])
+	if uint64(len(buffer)-4) < uint64(n) {
+		return fmt.Errorf("node id length %d out of range", n)
+	}
+	m.NodeID = string(buffer[4 : 4+n])
+	m.Text = string(buffer[4+n:])
+	return nil
+}
+
+// PreviewMetadata tells which node the preview belongs to
+type PreviewMetadata struct {
+	NodeID        string `json:"node_id"`
+	PromptID      string `json:"prompt_id"`
+	DisplayNodeID string `json:"display_node_id"`
+	ParentNodeID  string `json:"parent_node_id"`
+	RealNodeID    string `json:"real_node_id"`
+	// ImageType is the MIME type, e.g.: "image/png"
+	ImageType string `json:"image_type"`
+}
+
+type DataImageWithMetadata struct {
+	Metadata PreviewMetadata
+	Blob     []byte
+}
+
+func (m *DataImageWithMetadata) UnmarshalBinary(buffer []byte) error {
+	if len(buffer) < 4 {
+		return fmt.Errorf("image data length too short")
+	}
+	n := binary.BigEndian.Uint32(buffer[:4])
+	if uint64(len(buffer)-4) < uint64(n) {
+		return fmt.Errorf("metadata length %d out of range", n)
+	}
+	if err := json.Unmarshal(buffer[4:4+n], &m.Metadata); err != nil {
+		return fmt.Errorf("unmarshal metadata: %w", err)
+	}
+	m.Blob = buffer[4+n:]
+	return nil
+}
+
+// Type return the image type of the MIME type in metadata, zero if unknown
+func (m *DataImageWithMetadata) Type() ImageType {
+	switch strings.ToLower(m.Metadata.ImageType) {
+	case "image/jpeg", "image/jpg":
+		return JPEG
+	case "image/png":
+		return PNG
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,107 @@
+package message
+
+import (
+	"encoding"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func frame(t EventType, payload ...[]byte) []byte {
+	p := binary.BigEndian.AppendUint32(nil, uint32(t))
+	for _, b := range payload {
+		p = append(p, b...)
+	}
+	return p
+}
+
+func u32(n int) []byte {
+	return binary.BigEndian.AppendUint32(nil, uint32(n))
+}
+
+type customData struct {
+	raw []byte
+}
+
+func (d *customData) UnmarshalBinary(p []byte) error {
+	d.raw = p
+	return nil
+}
+
+func TestBinaryMessage_UnmarshalBinary(t *testing.T) {
+	const custom EventType = 100
+	RegisterBinaryType(custom, func() encoding.BinaryUnmarshaler { return &customData{} })
+
+	metadata := `{"node_id":"9","prompt_id":"p1","display_node_id":"9","image_type":"image/png"}`
+	tests := []struct {
+		name    string
+		input   []byte
+		want    any
+		wantErr error
+	}{
+		{
+			name:  "preview image",
+			input: frame(PreviewImage, u32(int(JPEG)), []byte("jpeg")),
+			want:  &DataImage{Type: JPEG, Blob: []byte("jpeg")},
+		},
+		{
+			name:  "unencoded preview image",
+			input: frame(UnencodedPreviewImage, u32(int(PNG)), []byte("png")),
+			want:  &DataImage{Type: PNG, Blob: []byte("png")},
+		},
+		{
+			name:  "text",
+			input: frame(Text, u32(2), []byte("12"), []byte("step 1/20")),
+			want:  &DataText{NodeID: "12", Text: "step 1/20"},
+		},
+		{
+			name:  "preview image with metadata",
+			input: frame(PreviewImageWithMetadata, u32(len(metadata)), []byte(metadata), []byte("png")),
+			want: &DataImageWithMetadata{
+				Metadata: PreviewMetadata{NodeID: "9", PromptID: "p1", DisplayNodeID: "9", ImageType: "image/png"},
+				Blob:     []byte("png"),
+			},
+		},
+		{
+			name:  "custom",
+			input: frame(custom, []byte("any")),
+			want:  &customData{raw: []byte("any")},
+		},
+		{
+			name:    "unknown",
+			input:   frame(99, []byte("any")),
+			wantErr: ErrUnknownBinaryType,
+		},
+		{
+			name:    "text length out of range",
+			input:   frame(Text, u32(10), []byte("12")),
+			wantErr: errAny,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got BinaryMessage
+			err := got.UnmarshalBinary(tt.input)
+			if tt.wantErr != nil {
+				if err == nil || tt.wantErr != errAny && !errors.Is(err, tt.wantErr) {
+					t.Errorf("UnmarshalBinary() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("UnmarshalBinary() error = %v", err)
+			}
+			if !reflect.DeepEqual(got.Data, tt.want) {
+				t.Errorf("UnmarshalBinary() = %#v, want %#v", got.Data, tt.want)
+			}
+		})
+	}
+
+	d := &DataImageWithMetadata{Metadata: PreviewMetadata{ImageType: "image/png"}}
+	if d.Type() != PNG {
+		t.Errorf("Type() = %v, want %v", d.Type(), PNG)
+	}
+}
+
+var errAny = errors.New("any error")
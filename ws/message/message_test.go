@@ -259,3 +259,102 @@ func TestDataProgress_UnmarshalJSON(t *testing.T) {
 func stringPtr(s string) *string {
 	return &s
 }
+
+type customMonitor struct {
+	ExecutionBase
+	CPU float64 `json:"cpu_utilization"`
+}
+
+func TestMessage_UnmarshalJSON(t *testing.T) {
+	RegisterType("crystools.monitor", func() Data { return &customMonitor{} })
+
+	tests := []struct {
+		name  string
+		input string
+		want  Message
+	}{
+		{
+			name:  "status with sid",
+			input: `{"type":"status","data":{"status":{"exec_info":{"queue_remaining":1}},"sid":"c1"}}`,
+			want: Message{
+				Type: Status,
+				Data: func() Data {
+					d := &DataStatus{SID: stringPtr("c1")}
+					d.Status.ExecInfo.QueueRemaining = 1
+					return d
+				}(),
+			},
+		},
+		{
+			name:  "executed with display node",
+			input: `{"type":"executed","data":{"node":"12","display_node":"7","output":{"text":["hi"]},"prompt_id":"p1"}}`,
+			want: Message{
+				Type: Executed,
+				Data: &DataExecuted{
+					DataExecuting: DataExecuting{
+						ExecutionBase: ExecutionBase{PromptID: "p1"},
+						Node:          stringPtr("12"),
+						DisplayNode:   stringPtr("7"),
+					},
+					Output: MapOutput{"text": json.RawMessage(`["hi"]`)},
+				},
+			},
+		},
+		{
+			name: "progress state",
+			input: `{"type":"progress_state","data":{"prompt_id":"p1","nodes":{
+				"3":{"value":5,"max":20,"state":"running","node_id":"3","prompt_id":"p1","display_node_id":"3","parent_node_id":null,"real_node_id":"3"},
+				"4":{"value":1,"max":1,"state":"finished","node_id":"4","prompt_id":"p1"}}}}`,
+			want: Message{
+				Type: ProgressState,
+				Data: &DataProgressState{
+					ExecutionBase: ExecutionBase{PromptID: "p1"},
+					Nodes: map[string]NodeProgress{
+						"3": {NodeID: "3", PromptID: "p1", Value: 5, Max: 20, State: NodeStateRunning, DisplayNodeID: "3", RealNodeID: "3"},
+						"4": {NodeID: "4", PromptID: "p1", Value: 1, Max: 1, State: NodeStateFinished},
+					},
+				},
+			},
+		},
+		{
+			name:  "registered type",
+			input: `{"type":"crystools.monitor","data":{"cpu_utilization":12.5}}`,
+			want:  Message{Type: "crystools.monitor", Data: &customMonitor{CPU: 12.5}},
+		},
+		{
+			name:  "unknown type",
+			input: `{"type":"vhs_latentpreview","data":{"length":10,"rate":8}}`,
+			want:  Message{Type: "vhs_latentpreview"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Message
+			if err := json.Unmarshal([]byte(tt.input), &got); err != nil {
+				t.Fatalf("Unmarshal() error = %v", err)
+			}
+			if got.Type != tt.want.Type {
+				t.Errorf("Type = %v, want %v", got.Type, tt.want.Type)
+			}
+			if !reflect.DeepEqual(got.Data, tt.want.Data) {
+				t.Errorf("Data = %#v, want %#v", got.Data, tt.want.Data)
+			}
+			var raw struct {
+				Data json.RawMessage `json:"data"`
+			}
+			_ = json.Unmarshal([]byte(tt.input), &raw)
+			if string(got.RawData) != string(raw.Data) {
+				t.Errorf("RawData = %s, want %s", got.RawData, raw.Data)
+			}
+		})
+	}
+
+	state := &DataProgressState{Nodes: map[string]NodeProgress{
+		"4": {NodeID: "4", State: NodeStateRunning},
+		"3": {NodeID: "3", State: NodeStateRunning},
+		"5": {NodeID: "5", State: NodeStateFinished},
+	}}
+	if got := state.Running(); len(got) != 2 || got[0].NodeID != "3" || got[1].NodeID != "4" {
+		t.Errorf("Running() = %v", got)
+	}
+}
@@ -0,0 +1,88 @@
+package ws
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// ErrGiveUp is returned by Err when the client stops reconnecting
+var ErrGiveUp = errors.New("ws give up reconnecting")
+
+// ReconnectConfig is the backoff policy of reconnecting
+type ReconnectConfig struct {
+	// MaxAttempts is the max consecutive failed attempts before giving up, zero means no limit
+	MaxAttempts int `mapstructure:"max_attempts"`
+	// InitialBackoff is the delay after the first failed attempt, default is 500ms
+	InitialBackoff time.Duration `mapstructure:"initial_backoff"`
+	// MaxBackoff is the max delay between attempts, default is 30s
+	MaxBackoff time.Duration `mapstructure:"max_backoff"`
+	// Multiplier grows the delay for each attempt, default is 2
+	Multiplier float64 `mapstructure:"multiplier"`
+	// Jitter randomizes the delay by the ratio in [0, 1], default is 0.2
+	Jitter float64 `mapstructure:"jitter"`
+	// Disable give up on the first disconnect
+	Disable bool `mapstructure:"disable"`
+}
+
+func (p ReconnectConfig) withDefault() ReconnectConfig {
+	if p.InitialBackoff <= 0 {
+		p.InitialBackoff = 500 * time.Millisecond
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = 30 * time.Second
+	}
+	if p.Multiplier < 1 {
+		p.Multiplier = 2
+	}
+	if p.Jitter <= 0 || p.Jitter > 1 {
+		p.Jitter = 0.2
+	}
+	return p
+}
+
+// backoff return the delay after the attempt-th failed attempt
+func (p ReconnectConfig) backoff(attempt int) time.Duration {
+	d := float64(p.InitialBackoff) * math.Pow(p.Multiplier, float64(attempt-1))
+	d = math.Min(d, float64(p.MaxBackoff))
+	d += d * p.Jitter * (rand.Float64()*2 - 1)
+	return time.Duration(d)
+}
+
+func WithReconnect(p ReconnectConfig) Option {
+	return func(c *Client) {
+		c.reconnect = p.withDefault()
+	}
+}
+
+// WithContext close the connection and give up reconnecting when ctx is done,
+// e.g.: the owner has gone away
+func WithContext(ctx context.Context) Option {
+	return func(c *Client) {
+		c.parent = ctx
+	}
+}
+
+// WithOnDisconnect call fn when the connection is lost,
+// the messages are lost until reconnected
+func WithOnDisconnect(fn func(err error)) Option {
+	return func(c *Client) {
+		c.onDisconnect = fn
+	}
+}
+
+// WithOnReconnect call fn when the connection is restored after attempts
+func WithOnReconnect(fn func(attempts int)) Option {
+	return func(c *Client) {
+		c.onReconnect = fn
+	}
+}
+
+// WithOnGiveUp call fn when the client stops reconnecting, err wraps ErrGiveUp
+func WithOnGiveUp(fn func(err error)) Option {
+	return func(c *Client) {
+		c.onGiveUp = fn
+	}
+}
@@ -1,32 +1,106 @@
 package ws
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"net"
+	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
 
+	"github.com/sko00o/comfyui-go/iface"
 	"github.com/sko00o/comfyui-go/logger"
 )
 
 type Client struct {
 	ClientID string
 	urlStr   string
+	endpoint string
 	handler  Handler
 	log      logger.Logger
 
+	// parent gives up reconnecting, ctx is also canceled by Close
+	parent    context.Context
+	ctx       context.Context
+	cancel    context.CancelFunc
 	done      chan struct{}
 	isClosing atomic.Bool
+	closeOnce sync.Once
+	err       error
 
+	reconnect    ReconnectConfig
+	onDisconnect func(err error)
+	onReconnect  func(attempts int)
+	onGiveUp     func(err error)
+	keepalive    KeepaliveConfig
+
+	dialer      *websocket.Dialer
+	header      http.Header
+	tlsConfig   *tls.Config
+	middlewares []func(Handler) Handler
+	metrics     iface.Metrics
+
+	// dispatch runs the handler and callbacks off the read goroutine
+	dispatch *dispatcher
+	// readDone is closed when the reading stops, before the queued messages are delivered
+	readDone chan struct{}
+	// overflow is set while the messages are dropped, only used by the read goroutine
+	overflow bool
+
+	mu   sync.Mutex
 	conn *websocket.Conn
 }
 
-func New(u url.URL, clientID string, handler Handler, l logger.LoggerExtend) (*Client, error) {
+type Option func(c *Client)
+
+// WithDialer replace the default dialer
+func WithDialer(dialer *websocket.Dialer) Option {
+	return func(c *Client) {
+		c.dialer = dialer
+	}
+}
+
+// WithHeader set the headers of handshake request, e.g.: auth headers
+func WithHeader(header http.Header) Option {
+	return func(c *Client) {
+		c.header = header
+	}
+}
+
+func WithTLSConfig(tlsConfig *tls.Config) Option {
+	return func(c *Client) {
+		c.tlsConfig = tlsConfig
+	}
+}
+
+// WithMiddleware wrap the handler, e.g.: record the frames,
+// the first middleware is the outermost
+func WithMiddleware(mw func(next Handler) Handler) Option {
+	return func(c *Client) {
+		c.middlewares = append(c.middlewares, mw)
+	}
+}
+
+// WithMetrics report the reconnects to m
+func WithMetrics(m iface.Metrics) Option {
+	return func(c *Client) {
+		c.metrics = m
+	}
+}
+
+// New connect to ComfyUI and deliver the messages to handler in a goroutine,
+// the callbacks are also called in it, the reading goes on while handler is running
+func New(u url.URL, clientID string, handler Handler, l logger.LoggerExtend, opts ...Option) (*Client, error) {
 	u.Scheme = strings.Replace(u.Scheme, "http", "ws", 1)
-	u.Path = "/ws"
+	// keep the path prefix, e.g.: ComfyUI behind a reverse proxy at /comfy/
+	u = *u.JoinPath("ws")
 	q := u.Query()
 	q.Set("clientId", clientID)
 	u.RawQuery = q.Encode()
@@ -35,80 +109,232 @@ func New(u url.URL, clientID string, handler Handler, l logger.LoggerExtend) (*C
 	c := &Client{
 		ClientID: clientID,
 		urlStr:   urlStr,
+		endpoint: u.Host,
 		handler:  handler,
 		log:      l.With("client_id", clientID),
 
+		parent:    context.Background(),
 		done:      make(chan struct{}),
 		isClosing: atomic.Bool{},
+		reconnect: ReconnectConfig{}.withDefault(),
+		keepalive: KeepaliveConfig{}.withDefault(),
+		dispatch:  newDispatcher(),
+		readDone:  make(chan struct{}),
+
+		metrics: iface.NopMetrics{},
+	}
+	for _, opt := range opts {
+		opt(c)
 	}
 
-	return c, c.connect()
-}
+	dialer := *websocket.DefaultDialer
+	if c.dialer != nil {
+		dialer = *c.dialer
+	}
+	if c.tlsConfig != nil {
+		dialer.TLSClientConfig = c.tlsConfig
+	}
+	c.dialer = &dialer
+	for i := len(c.middlewares) - 1; i >= 0; i-- {
+		c.handler = c.middlewares[i](c.handler)
+	}
 
-func (c *Client) connect() error {
-	conn, _, err := websocket.DefaultDialer.Dial(c.urlStr, nil)
+	c.ctx, c.cancel = context.WithCancel(c.parent)
+	conn, err := c.dial()
 	if err != nil {
-		return fmt.Errorf("dial: %w", err)
+		c.cancel()
+		c.dispatch.close()
+		return nil, err
 	}
-	c.conn = conn
 	c.log.Debugf("ws connected")
-	go c.readLoop()
-	return nil
+	go c.run(conn)
+	return c, nil
 }
 
-func (c *Client) reconnect() {
-	c.log.Warnf("ws reconnecting...")
-	if c.conn != nil {
-		_ = c.conn.Close()
-	}
-	for {
-		if err := c.connect(); err != nil {
-			c.log.Errorf("ws reconnect: %v", err)
-			time.Sleep(time.Second)
-			continue
-		}
-		break
+func (c *Client) dial() (*websocket.Conn, error) {
+	conn, _, err := c.dialer.DialContext(c.ctx, c.urlStr, c.header)
+	if err != nil {
+		return nil, fmt.Errorf("dial: %w", err)
 	}
+	c.mu.Lock()
+	c.conn = conn
+	c.mu.Unlock()
+	return conn, nil
 }
 
-func (c *Client) Close() error {
-	if c.conn == nil {
-		return nil
-	}
+// Done is closed when the client is closed or gives up reconnecting
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
 
-	c.isClosing.Store(true)
-	err := c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
-	if err != nil {
-		return err
-	}
+// Err return the error wrapping ErrGiveUp after Done is closed, nil if closed by Close
+func (c *Client) Err() error {
 	select {
 	case <-c.done:
-	case <-time.After(5 * time.Second):
+		return c.err
+	default:
+		return nil
 	}
-	return c.conn.Close()
 }
 
-func (c *Client) readLoop() {
+// run read conn and reconnect until closed or given up
+func (c *Client) run(conn *websocket.Conn) {
 	defer func() {
+		close(c.readDone)
+		// the messages read are still delivered
+		c.dispatch.close()
+		close(c.done)
+	}()
+	for {
+		err := c.readLoop(conn)
+		_ = conn.Close()
 		if c.isClosing.Load() {
-			close(c.done)
 			c.log.Debugf("ws closing...")
-		} else {
-			c.reconnect()
+			return
 		}
-	}()
-	for {
-		messageType, message, err := c.conn.ReadMessage()
+
+		c.log.Errorf("ws read: %v", err)
+		if c.onDisconnect != nil {
+			// err is reused by reconnecting
+			readErr := err
+			c.dispatch.pushAlways(func() { c.onDisconnect(readErr) })
+		}
+		conn, err = c.reconnectLoop()
 		if err != nil {
-			if websocket.IsCloseError(err, websocket.CloseNormalClosure) && c.isClosing.Load() {
+			if c.isClosing.Load() {
 				return
 			}
-			c.log.Errorf("ws read: %v", err)
+			c.err = err
+			c.log.Errorf("ws reconnect: %v", err)
+			if c.onGiveUp != nil {
+				c.dispatch.pushAlways(func() { c.onGiveUp(err) })
+			}
 			return
 		}
+	}
+}
+
+func (c *Client) reconnectLoop() (*websocket.Conn, error) {
+	if c.reconnect.Disable {
+		return nil, fmt.Errorf("%w: reconnect disabled", ErrGiveUp)
+	}
+	c.log.Warnf("ws reconnecting...")
+	for attempt := 1; ; attempt++ {
+		conn, err := c.dial()
+		if err == nil {
+			c.log.Infof("ws reconnected after %d attempts", attempt)
+			c.metrics.WsReconnect(c.endpoint)
+			if c.onReconnect != nil {
+				c.dispatch.pushAlways(func() { c.onReconnect(attempt) })
+			}
+			return conn, nil
+		}
+		if c.ctx.Err() != nil {
+			return nil, fmt.Errorf("%w: %w", ErrGiveUp, context.Cause(c.ctx))
+		}
+		if c.reconnect.MaxAttempts > 0 && attempt >= c.reconnect.MaxAttempts {
+			return nil, fmt.Errorf("%w after %d attempts: %w", ErrGiveUp, attempt, err)
+		}
+
+		wait := c.reconnect.backoff(attempt)
+		c.log.Warnf("ws reconnect attempt %d: %v, retry in %v", attempt, err, wait)
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-c.ctx.Done():
+			timer.Stop()
+			return nil, fmt.Errorf("%w: %w", ErrGiveUp, context.Cause(c.ctx))
+		}
+	}
+}
+
+// Close the connection and wait for the queued messages to be delivered,
+// it does not wait if a handler or callback is running, e.g.: Close is called by it,
+// wait for Done then to be sure no more message is delivered
+func (c *Client) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		c.isClosing.Store(true)
+		// stop reconnecting
+		c.cancel()
+		select {
+		case <-c.readDone:
+			// gave up, the conn is closed
+		default:
+			err = c.closeConn()
+		}
+		<-c.readDone
+		if !c.dispatch.running() {
+			<-c.done
+		}
+	})
+	return err
+}
+
+// closeConn send the close message and close the conn after the server replies
+func (c *Client) closeConn() error {
+	c.mu.Lock()
+	conn := c.conn
+	c.mu.Unlock()
+
+	err := conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+		time.Now().Add(c.keepalive.WriteWait))
+	switch {
+	case err == nil:
+		select {
+		case <-c.readDone:
+		case <-time.After(5 * time.Second):
+		}
+	case errors.Is(err, net.ErrClosed):
+		// dropped while reconnecting
+		err = nil
+	}
+	if closeErr := conn.Close(); err == nil && !errors.Is(closeErr, net.ErrClosed) {
+		err = closeErr
+	}
+	return err
+}
+
+func (c *Client) readLoop(conn *websocket.Conn) error {
+	stop := make(chan struct{})
+	defer close(stop)
+	// the parent is gone, the reading is stopped and it gives up reconnecting
+	stopClose := context.AfterFunc(c.parent, func() { _ = conn.Close() })
+	defer stopClose()
+	if err := c.startKeepalive(conn, stop); err != nil {
+		return fmt.Errorf("keepalive: %w", err)
+	}
+
+	for {
+		messageType, message, err := conn.ReadMessage()
+		if err != nil {
+			return c.readErr(err)
+		}
+		// any message proves the connection is alive
+		if err := c.extendDeadline(conn); err != nil {
+			return err
+		}
 
 		if c.handler != nil {
-			c.handler.HandleMessage(messageType, message)
+			c.deliver(messageType, message)
 		}
 	}
 }
+
+// deliver queue the message for handler, it is dropped if the handler falls behind,
+// and OnDisconnect is called once for the messages dropped in a row
+func (c *Client) deliver(messageType int, message []byte) {
+	if c.dispatch.push(func() { c.handler.HandleMessage(messageType, message) }) {
+		c.overflow = false
+		return
+	}
+	if c.overflow {
+		return
+	}
+	c.overflow = true
+	c.log.Warnf("ws handler falls behind, drop messages")
+	if c.onDisconnect != nil {
+		c.dispatch.pushAlways(func() { c.onDisconnect(ErrHandlerOverflow) })
+	}
+}
@@ -0,0 +1,367 @@
+package ws
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sko00o/comfyui-go/logger"
+)
+
+// dropServer send the number of connection then drop it,
+// it is behind a reverse proxy at /comfy/
+func dropServer(t *testing.T) (*httptest.Server, *atomic.Int32) {
+	var conns atomic.Int32
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/comfy/ws" {
+			http.NotFound(w, r)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		n := conns.Add(1)
+		_ = conn.WriteMessage(websocket.TextMessage, []byte{byte('0' + n)})
+		_ = conn.Close()
+	}))
+	return srv, &conns
+}
+
+func fastReconnect(maxAttempts int) ReconnectConfig {
+	return ReconnectConfig{
+		MaxAttempts:    maxAttempts,
+		InitialBackoff: 10 * time.Millisecond,
+		MaxBackoff:     20 * time.Millisecond,
+	}
+}
+
+func TestClient_Reconnect(t *testing.T) {
+	srv, conns := dropServer(t)
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL + "/comfy/")
+
+	msgs := make(chan string, 10)
+	var disconnects, reconnects atomic.Int32
+	c, err := New(*u, "c1", HandlerFunc(func(_ int, p []byte) {
+		msgs <- string(p)
+	}), logger.NewStd(),
+		WithReconnect(fastReconnect(0)),
+		WithOnDisconnect(func(error) { disconnects.Add(1) }),
+		WithOnReconnect(func(int) { reconnects.Add(1) }),
+	)
+	require.NoError(t, err)
+
+	for _, want := range []string{"1", "2", "3"} {
+		select {
+		case got := <-msgs:
+			assert.Equal(t, want, got)
+		case <-time.After(time.Second):
+			t.Fatal("no message")
+		}
+	}
+	require.NoError(t, c.Close())
+	assert.NoError(t, c.Err())
+	assert.GreaterOrEqual(t, disconnects.Load(), int32(2))
+	assert.GreaterOrEqual(t, reconnects.Load(), int32(2))
+	assert.GreaterOrEqual(t, conns.Load(), int32(3))
+}
+
+func TestClient_GiveUp(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		cfg    ReconnectConfig
+		cancel bool
+	}{
+		{name: "max attempts", cfg: fastReconnect(3)},
+		{name: "context", cfg: fastReconnect(0), cancel: true},
+		{name: "disabled", cfg: ReconnectConfig{Disable: true}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv, _ := dropServer(t)
+			u, _ := url.Parse(srv.URL + "/comfy/")
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			gaveUp := make(chan error, 1)
+			c, err := New(*u, "c1", HandlerFunc(func(int, []byte) {
+				// stop the server after the first message
+				srv.Close()
+				if tc.cancel {
+					cancel()
+				}
+			}), logger.NewStd(),
+				WithContext(ctx),
+				WithReconnect(tc.cfg),
+				WithOnGiveUp(func(err error) { gaveUp <- err }),
+			)
+			require.NoError(t, err)
+
+			select {
+			case <-c.Done():
+			case <-time.After(5 * time.Second):
+				t.Fatal("not give up")
+			}
+			assert.True(t, errors.Is(c.Err(), ErrGiveUp))
+			assert.True(t, errors.Is(<-gaveUp, ErrGiveUp))
+			if tc.cancel {
+				assert.True(t, errors.Is(c.Err(), context.Canceled))
+			}
+			assert.NoError(t, c.Close())
+		})
+	}
+}
+
+func TestReconnectConfig_Backoff(t *testing.T) {
+	cfg := ReconnectConfig{
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     time.Second,
+		Jitter:         0.1,
+	}.withDefault()
+	for _, tc := range []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, 100 * time.Millisecond},
+		{2, 200 * time.Millisecond},
+		{4, 800 * time.Millisecond},
+		{10, time.Second},
+	} {
+		got := cfg.backoff(tc.attempt)
+		assert.InDelta(t, float64(tc.want), float64(got), float64(tc.want)*0.1, "attempt %d", tc.attempt)
+	}
+}
+
+func TestClient_Keepalive(t *testing.T) {
+	var conns atomic.Int32
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if conns.Add(1) == 1 {
+			// half-open, neither read nor answer the pings
+			<-r.Context().Done()
+			return
+		}
+		// pings are answered while reading
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL)
+
+	disconnected := make(chan error, 10)
+	reconnected := make(chan struct{}, 10)
+	c, err := New(*u, "c1", nil, logger.NewStd(),
+		WithReconnect(fastReconnect(0)),
+		WithKeepalive(KeepaliveConfig{PingInterval: 20 * time.Millisecond, PongWait: 100 * time.Millisecond}),
+		WithOnDisconnect(func(err error) { disconnected <- err }),
+		WithOnReconnect(func(int) { reconnected <- struct{}{} }),
+	)
+	require.NoError(t, err)
+	defer c.Close()
+
+	select {
+	case err := <-disconnected:
+		var netErr net.Error
+		assert.True(t, errors.As(err, &netErr) && netErr.Timeout(), "got %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("dead connection is not detected")
+	}
+	select {
+	case <-reconnected:
+	case <-time.After(time.Second):
+		t.Fatal("not reconnected")
+	}
+
+	// the healthy connection is kept alive by pongs
+	select {
+	case err := <-disconnected:
+		t.Fatalf("disconnected: %v", err)
+	case <-time.After(300 * time.Millisecond):
+	}
+	assert.Equal(t, int32(2), conns.Load())
+}
+
+func TestClient_SlowHandler(t *testing.T) {
+	var conns atomic.Int32
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conns.Add(1)
+		_ = conn.WriteMessage(websocket.TextMessage, []byte("slow"))
+		go func() {
+			time.Sleep(150 * time.Millisecond)
+			_ = conn.WriteMessage(websocket.TextMessage, []byte("fast"))
+		}()
+		// pings are answered while reading
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL)
+
+	msgs := make(chan string, 10)
+	disconnected := make(chan error, 10)
+	c, err := New(*u, "c1", HandlerFunc(func(_ int, p []byte) {
+		if string(p) == "slow" {
+			// e.g.: a large download, longer than PongWait
+			time.Sleep(300 * time.Millisecond)
+		}
+		msgs <- string(p)
+	}), logger.NewStd(),
+		WithReconnect(fastReconnect(0)),
+		WithKeepalive(KeepaliveConfig{PingInterval: 20 * time.Millisecond, PongWait: 100 * time.Millisecond}),
+		WithOnDisconnect(func(err error) { disconnected <- err }),
+	)
+	require.NoError(t, err)
+	defer c.Close()
+
+	for _, want := range []string{"slow", "fast"} {
+		select {
+		case got := <-msgs:
+			assert.Equal(t, want, got)
+		case <-time.After(time.Second):
+			t.Fatalf("%s is not delivered", want)
+		}
+	}
+	select {
+	case err := <-disconnected:
+		t.Fatalf("disconnected: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+	assert.Equal(t, int32(1), conns.Load())
+}
+
+// burstServer send n messages on connect and keep the connection
+func burstServer(n int) *httptest.Server {
+	upgrader := websocket.Upgrader{}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for i := 0; i < n; i++ {
+			_ = conn.WriteMessage(websocket.TextMessage, []byte("m"))
+		}
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+}
+
+func TestClient_HandlerOverflow(t *testing.T) {
+	srv := burstServer(dispatchQueueSize * 2)
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL)
+
+	release := make(chan struct{})
+	var handled atomic.Int32
+	disconnected := make(chan error, 10)
+	c, err := New(*u, "c1", HandlerFunc(func(int, []byte) {
+		<-release
+		handled.Add(1)
+	}), logger.NewStd(),
+		WithOnDisconnect(func(err error) { disconnected <- err }),
+	)
+	require.NoError(t, err)
+	defer c.Close()
+
+	// the reading goes on and drops the messages instead of growing the queue
+	require.Eventually(t, func() bool {
+		return c.dispatch.dropped.Load() > 0
+	}, 5*time.Second, 10*time.Millisecond)
+	c.dispatch.mu.Lock()
+	assert.LessOrEqual(t, len(c.dispatch.queue), dispatchQueueSize+1)
+	c.dispatch.mu.Unlock()
+	close(release)
+	select {
+	case err := <-disconnected:
+		assert.ErrorIs(t, err, ErrHandlerOverflow)
+	case <-time.After(time.Second):
+		t.Fatal("overflow is not reported")
+	}
+	assert.Less(t, handled.Load(), int32(dispatchQueueSize*2))
+	assert.NoError(t, c.Err())
+}
+
+func TestClient_CloseInHandler(t *testing.T) {
+	srv := burstServer(1)
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL)
+
+	closed := make(chan error, 1)
+	var c *Client
+	ready := make(chan struct{})
+	c, err := New(*u, "c1", HandlerFunc(func(int, []byte) {
+		<-ready
+		closed <- c.Close()
+	}), logger.NewStd())
+	require.NoError(t, err)
+	close(ready)
+
+	select {
+	case err := <-closed:
+		assert.NoError(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("Close in handler deadlocks")
+	}
+	select {
+	case <-c.Done():
+	case <-time.After(time.Second):
+		t.Fatal("not done after the handler returns")
+	}
+}
+
+func TestClient_ContextCanceled(t *testing.T) {
+	// the server keeps the connection
+	srv := burstServer(1)
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	received := make(chan struct{}, 1)
+	c, err := New(*u, "c1", HandlerFunc(func(int, []byte) {
+		received <- struct{}{}
+	}), logger.NewStd(), WithContext(ctx))
+	require.NoError(t, err)
+	<-received
+
+	cancel()
+	select {
+	case <-c.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("the connection is alive after the context is canceled")
+	}
+	assert.ErrorIs(t, c.Err(), ErrGiveUp)
+	assert.ErrorIs(t, c.Err(), context.Canceled)
+	assert.NoError(t, c.Close())
+}